@@ -0,0 +1,334 @@
+package avif
+
+/*
+#include <avif/avif.h>
+*/
+import "C"
+import (
+	"context"
+	"fmt"
+	"image"
+	"io"
+	"time"
+)
+
+// Animation represents the frames of an AVIF image sequence and their timing, like gif.GIF.
+//   - Image: The successive frames, decoded and oriented like Decode does, or as the DecodeOptions select.
+//   - Delay: How long each frame is shown.
+//   - Keyframe: Whether each frame is a keyframe, i.e. can be decoded without the frames before it.
+//   - LoopCount: Number of times the animation loops, with the same semantics as gif.GIF: 0 loops forever, -1 shows
+//     each frame only once, and n > 0 shows each frame n+1 times.
+type Animation struct {
+	Image     []image.Image
+	Delay     []time.Duration
+	Keyframe  []bool
+	LoopCount int
+}
+
+// AnimationOptions represent the configuration options for encoding an animated AVIF image (image sequence).
+//   - Options: Encoding options applied to every frame. Crop, GridCellWidth and GridCellHeight are not supported.
+//   - Timescale: Number of time units per second used to store frame durations (default 1000).
+//   - KeyframeInterval: Maximum number of frames between keyframes; 0 lets the encoder decide (default 0).
+//   - LoopCount: Number of times the animation loops, with the same semantics as gif.GIF: 0 loops forever, -1 shows
+//     each frame only once, and n > 0 shows each frame n+1 times (default 0).
+type AnimationOptions struct {
+	Options
+	Timescale        uint64
+	KeyframeInterval int
+	LoopCount        int
+}
+
+// AnimationEncoder encodes a sequence of frames into an animated AVIF image.
+//
+// Frames are encoded as they are added, so only the compressed output is kept in memory. Close must be called to write
+// the image and free the native encoder, even if adding a frame failed.
+type AnimationEncoder struct {
+	writer   io.Writer
+	encoder  *C.avifEncoder
+	options  AnimationOptions
+	settings *Options
+	size     image.Point
+	frames   int
+	err      error
+}
+
+// NewAnimationEncoder creates an AnimationEncoder that writes to the provided writer when closed.
+//
+// Parameters:
+//   - writer: The destination where the encoded AVIF image will be written.
+//   - options: A pointer to an AnimationOptions struct that specifies encoding parameters. If nil, default values are
+//     used.
+//
+// Returns:
+//   - The encoder, or an error if the options are invalid.
+func NewAnimationEncoder(writer io.Writer, options *AnimationOptions) (*AnimationEncoder, error) {
+	if options == nil {
+		options = &AnimationOptions{Options: Options{Speed: 6, AlphaQuality: 60, ColorQuality: 60}}
+	}
+
+	opts := *options
+	if opts.Crop != nil {
+		return nil, fmt.Errorf("crop is not supported for animations")
+	}
+	if opts.GridCellWidth != 0 || opts.GridCellHeight != 0 {
+		return nil, fmt.Errorf("grid cells are not supported for animations")
+	}
+	if opts.Timescale == 0 {
+		opts.Timescale = 1000
+	}
+	if opts.KeyframeInterval < 0 {
+		return nil, fmt.Errorf("keyframe interval must not be negative")
+	}
+	if opts.LoopCount < -1 {
+		return nil, fmt.Errorf("loop count must be -1 or greater")
+	}
+
+	return &AnimationEncoder{writer: writer, options: opts}, nil
+}
+
+// AddFrame encodes img as the next frame of the animation, shown for the given delay.
+//
+// All frames must have the same dimensions, which must not exceed 16384x8704 pixels. Once adding a frame fails, every
+// following call returns the same error.
+func (e *AnimationEncoder) AddFrame(img image.Image, delay time.Duration) error {
+	if e.err == nil {
+		e.err = e.addFrame(img, delay)
+	}
+	return e.err
+}
+
+// addFrame converts img to a single cell and hands it to the native encoder.
+func (e *AnimationEncoder) addFrame(img image.Image, delay time.Duration) error {
+	if delay < 0 {
+		return fmt.Errorf("frame delay must not be negative")
+	}
+
+	size := img.Bounds().Size()
+	if e.encoder == nil {
+		if err := e.start(img); err != nil {
+			return err
+		}
+	} else if size != e.size {
+		return fmt.Errorf("frame %d is %dx%d, expected %dx%d", e.frames, size.X, size.Y, e.size.X, e.size.Y)
+	}
+
+	cell, err := createAVIFTile(newPixels(img, *e.settings), 0, 0, *e.settings)
+	if err != nil {
+		return err
+	}
+	defer C.avifImageDestroy(cell)
+
+	cells := []*C.avifImage{cell}
+	if err = setImageTransform(cells, size.X, size.Y, *e.settings); err != nil {
+		return err
+	}
+
+	result := C.avifEncoderAddImage(e.encoder, cell, C.uint64_t(e.duration(delay)), C.AVIF_ADD_IMAGE_FLAG_NONE)
+	if result != C.AVIF_RESULT_OK {
+		return fmt.Errorf("failed to add frame %d: %w", e.frames, encoderError(result, e.encoder))
+	}
+
+	e.frames++
+	return nil
+}
+
+// Close finishes the animation, writes it to the writer and frees the native encoder. At least one frame must have
+// been added. If adding a frame failed, nothing is written and that error is returned.
+func (e *AnimationEncoder) Close() error {
+	if e.encoder == nil {
+		if e.err != nil {
+			return e.err
+		}
+		return fmt.Errorf("animation has no frames")
+	}
+	defer func() {
+		C.avifEncoderDestroy(e.encoder)
+		e.encoder = nil
+	}()
+
+	if e.err != nil {
+		return e.err
+	}
+
+	data, err := finishEncoder(e.encoder)
+	if err != nil {
+		return err
+	}
+
+	if _, err = e.writer.Write(data); err != nil {
+		return fmt.Errorf("failed to write AVIF image: %v", err)
+	}
+
+	return nil
+}
+
+// start validates the options against the first frame and creates the native encoder.
+func (e *AnimationEncoder) start(first image.Image) error {
+	size := first.Bounds().Size()
+	if size.X == 0 || size.Y == 0 {
+		return fmt.Errorf("invalid image dimensions: %dx%d", size.X, size.Y)
+	}
+	if size.X > maxTileWidth || size.Y > maxTileHeight {
+		return fmt.Errorf("animation frames must not exceed %dx%d pixels", maxTileWidth, maxTileHeight)
+	}
+
+	settings, err := prepareOptions(first, &e.options.Options)
+	if err != nil {
+		return err
+	}
+
+	encoder := newEncoder(settings)
+	if encoder == nil {
+		return fmt.Errorf("failed to create AVIF encoder")
+	}
+
+	encoder.timescale = C.uint64_t(e.options.Timescale)
+	encoder.keyframeInterval = C.int(e.options.KeyframeInterval)
+	switch e.options.LoopCount {
+	case 0:
+		encoder.repetitionCount = C.AVIF_REPETITION_COUNT_INFINITE
+	case -1:
+		encoder.repetitionCount = 0
+	default:
+		encoder.repetitionCount = C.int(e.options.LoopCount)
+	}
+
+	e.encoder = encoder
+	e.settings = &settings
+	e.size = size
+	return nil
+}
+
+// duration converts a frame delay to timescale units, rounding to the nearest unit but never below one.
+func (e *AnimationEncoder) duration(delay time.Duration) uint64 {
+	units := (uint64(delay)*e.options.Timescale + uint64(time.Second)/2) / uint64(time.Second)
+	if units == 0 {
+		units = 1
+	}
+	return units
+}
+
+// EncodeAnimation encodes a sequence of frames into an animated AVIF image and writes it to the provided writer.
+//
+// Parameters:
+//   - writer: The destination where the encoded AVIF image will be written.
+//   - frames: The frames of the animation, all with the same dimensions.
+//   - delays: How long each frame is shown; must have the same length as frames.
+//   - options: A pointer to an AnimationOptions struct that specifies encoding parameters. If nil, default values are
+//     used.
+//
+// Returns:
+//   - An error if encoding or writing fails, otherwise nil.
+func EncodeAnimation(writer io.Writer, frames []image.Image, delays []time.Duration, options *AnimationOptions) error {
+	return EncodeAnimationContext(context.Background(), writer, frames, delays, options)
+}
+
+// EncodeAnimationContext encodes an animation like EncodeAnimation, stopping when ctx is done. The context is checked
+// before each frame is added; SVT-AV1 cannot be interrupted, so a frame already being encoded completes first. The
+// native encoder is freed either way, and nothing is written.
+//
+// Returns:
+//   - ctx.Err() if ctx is done before encoding completes, an error if encoding or writing fails, otherwise nil.
+func EncodeAnimationContext(ctx context.Context, writer io.Writer, frames []image.Image, delays []time.Duration,
+	options *AnimationOptions) error {
+	if len(frames) == 0 {
+		return fmt.Errorf("animation has no frames")
+	}
+	if len(frames) != len(delays) {
+		return fmt.Errorf("got %d frames but %d delays", len(frames), len(delays))
+	}
+
+	encoder, err := NewAnimationEncoder(writer, options)
+	if err != nil {
+		return err
+	}
+
+	for i, frame := range frames {
+		if err = ctx.Err(); err != nil {
+			// Close reports the first error without writing anything
+			encoder.err = err
+			break
+		}
+		if err = encoder.AddFrame(frame, delays[i]); err != nil {
+			break
+		}
+	}
+
+	return encoder.Close()
+}
+
+// DecodeAll reads AVIF image data from the provided io.Reader and decodes every frame of it. Still images are returned
+// as an animation with a single frame.
+//
+// It returns the decoded animation or an error if the decoding process fails.
+func DecodeAll(reader io.Reader) (*Animation, error) {
+	return DecodeAllContext(context.Background(), reader, nil)
+}
+
+// DecodeAllWithOptions decodes every frame like DecodeAll, using the given options. If options is nil, default values
+// are used.
+//
+// Set the limits in the options when decoding untrusted data: MaxImageCount caps the number of frames, and every limit
+// is checked before the first frame is decoded.
+//
+// It returns the decoded animation or an error if the decoding process fails.
+func DecodeAllWithOptions(reader io.Reader, options *DecodeOptions) (*Animation, error) {
+	return DecodeAllContext(context.Background(), reader, options)
+}
+
+// DecodeAllContext decodes every frame like DecodeAllWithOptions, stopping when ctx is done. The context is checked
+// before each frame is decoded; a frame already being decoded completes first. The native decoder is freed either way.
+//
+// It returns the decoded animation or an error if the decoding process fails. If ctx is done, the error is ctx.Err().
+func DecodeAllContext(ctx context.Context, reader io.Reader, options *DecodeOptions) (*Animation, error) {
+	sequence, err := NewSequenceDecoderWithOptions(reader, options)
+	if err != nil {
+		return nil, err
+	}
+	defer sequence.Close()
+
+	count := sequence.FrameCount()
+	animation := &Animation{
+		Image:     make([]image.Image, count),
+		Delay:     make([]time.Duration, count),
+		Keyframe:  make([]bool, count),
+		LoopCount: sequence.LoopCount(),
+	}
+
+	// Frames are decoded in order, so libavif never has to go back to a keyframe
+	for i := 0; i < count; i++ {
+		if err = ctx.Err(); err != nil {
+			return nil, err
+		}
+		if animation.Image[i], err = sequence.Frame(i); err != nil {
+			return nil, err
+		}
+		if animation.Delay[i], err = sequence.Delay(i); err != nil {
+			return nil, err
+		}
+		animation.Keyframe[i] = sequence.IsKeyframe(i)
+	}
+
+	return animation, nil
+}
+
+// loopCount converts a libavif repetition count to the gif.GIF loop count semantics. Unknown counts loop forever, which
+// is what browsers do.
+func loopCount(repetitionCount C.int) int {
+	switch {
+	case repetitionCount < 0:
+		return 0
+	case repetitionCount == 0:
+		return -1
+	default:
+		return int(repetitionCount)
+	}
+}
+
+// frameDelay converts the timing of a frame to a time.Duration.
+func frameDelay(timing C.avifImageTiming) time.Duration {
+	if timing.timescale == 0 {
+		return 0
+	}
+	return time.Duration(uint64(timing.durationInTimescales) * uint64(time.Second) / uint64(timing.timescale))
+}
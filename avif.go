@@ -5,147 +5,228 @@ package avif
 #include <stdlib.h>
 #include <avif/avif.h>
 
-// Helper to get error string from avifResult
-const char* get_error_string(avifResult result) {
-    return avifResultToString(result);
-}
-
-// Full decode: creates a decoder, sets up the memory I/O, and decodes the image.
-// Returns the avifImage pointer (which contains width, height, etc.) and leaves the
-// decoder pointer for cleanup. Returns error result via outResult.
-avifImage* decode_avif_image(const uint8_t * data, size_t size, avifDecoder ** outDecoder, avifResult *outResult) {
-    avifDecoder* decoder = avifDecoderCreate();
-    // Force libavif to use the dav1d backend.
-    decoder->codecChoice = AVIF_CODEC_CHOICE_DAV1D;
-
-    *outResult = avifDecoderSetIOMemory(decoder, data, size);
-    if (*outResult != AVIF_RESULT_OK) {
-        avifDecoderDestroy(decoder);
-        return NULL;
+// Reports the dimensions of a parsed image as displayed, after the clean aperture crop and rotation.
+void get_display_size(const avifImage * image, uint32_t * width, uint32_t * height) {
+    *width = image->width;
+    *height = image->height;
+    if (image->transformFlags & AVIF_TRANSFORM_CLAP) {
+        avifCropRect rect;
+        avifDiagnostics diag;
+        if (avifCropRectFromCleanApertureBox(&rect, &image->clap, image->width, image->height, &diag)) {
+            *width = rect.width;
+            *height = rect.height;
+        }
     }
-
-    *outResult = avifDecoderParse(decoder);
-    if (*outResult != AVIF_RESULT_OK) {
-        avifDecoderDestroy(decoder);
-        return NULL;
-    }
-
-    *outResult = avifDecoderNextImage(decoder);
-    if (*outResult != AVIF_RESULT_OK) {
-        avifDecoderDestroy(decoder);
-        return NULL;
-    }
-
-    if (outDecoder) {
-        *outDecoder = decoder;
-    }
-    return decoder->image;
-}
-
-// Config-only decode: reads the header and returns width and height.
-// Returns error result via outResult.
-void get_avif_config(const uint8_t * data, size_t size, uint32_t * width, uint32_t * height, avifResult *outResult) {
-    avifDecoder* decoder = avifDecoderCreate();
-    // Force libavif to use the dav1d backend.
-    decoder->codecChoice = AVIF_CODEC_CHOICE_DAV1D;
-
-    *outResult = avifDecoderSetIOMemory(decoder, data, size);
-    if (*outResult != AVIF_RESULT_OK) {
-         *width = 0;
-         *height = 0;
-         avifDecoderDestroy(decoder);
-         return;
-    }
-
-    *outResult = avifDecoderParse(decoder);
-    if (*outResult != AVIF_RESULT_OK) {
-         *width = 0;
-         *height = 0;
-         avifDecoderDestroy(decoder);
-         return;
+    if ((image->transformFlags & AVIF_TRANSFORM_IROT) && (image->irot.angle % 2 == 1)) {
+        uint32_t tmp = *width;
+        *width = *height;
+        *height = tmp;
     }
-
-    *width = decoder->image->width;
-    *height = decoder->image->height;
-    avifDecoderDestroy(decoder);
 }
 */
 import "C"
 import (
+	"context"
+	"encoding/binary"
 	"fmt"
 	"image"
 	"image/color"
+	"runtime"
+	"strings"
 	"unsafe"
 )
 
-// encodeAVIF encodes an RGBA image to AVIF format.
+// Max dimensions supported by SVT-AV1. Larger images are split into a grid of cells of this size, unless the options
+// ask for smaller cells.
+const (
+	maxTileWidth  = 16384
+	maxTileHeight = 8704
+)
+
+// rgbPixels holds interleaved RGBA samples ready to be handed to libavif, or single-channel luma samples when gray is
+// set. Samples are either 8-bit, or 16-bit in native byte order when depth is 16.
+type rgbPixels struct {
+	pix           []byte
+	stride        int
+	width         int
+	height        int
+	depth         int
+	premultiplied bool
+	gray          bool
+}
+
+// bytesPerPixel returns the size of one pixel in pix.
+func (p rgbPixels) bytesPerPixel() int {
+	if p.gray {
+		return p.depth / 8
+	}
+	return 4 * (p.depth / 8)
+}
+
+// encodeAVIF encodes RGBA pixels to AVIF format.
 //
 // Speed ranges from 0 (slowest, best quality) to 10 (fastest, lower quality).
 //
-// ColorQuality and AlphaQuality range from 0 (worst) to 100 (lossless).
+// ColorQuality and AlphaQuality range from 0 (worst) to 100 (best). SVT-AV1 has no lossless mode.
 //
-// Uses tiling to support images larger than SVT-AV1's dimension limits. For images within limits, creates a single tile
-// (1x1 grid) with identical performance.
-func encodeAVIF(rgba image.RGBA, options Options) ([]byte, error) {
-	width := rgba.Bounds().Dx()
-	height := rgba.Bounds().Dy()
+// Depth is the bit depth of the encoded image (8 or 10), independent of the depth of the source pixels. Every tile is
+// created with 4:2:0 chroma subsampling, the only format SVT-AV1 encodes.
+//
+// Uses a grid to support images larger than SVT-AV1's dimension limits, or than GridCellWidth x GridCellHeight. For
+// images within limits, creates a single cell (1x1 grid) with identical performance.
+//
+// ctx is checked before each tile is converted and before the grid is encoded. Encoding is a single native call, so it
+// runs to completion once started.
+func encodeAVIF(ctx context.Context, pixels rgbPixels, options Options) ([]byte, error) {
+	grid, err := newImageGrid(ctx, pixels, options)
+	if err != nil {
+		return nil, err
+	}
+	defer grid.destroy()
+
+	return grid.encode(ctx, options)
+}
+
+// imageGrid holds the grid cells of an image, already converted to YUV, so it can be encoded several times with
+// different settings without converting the pixels again.
+type imageGrid struct {
+	cells []*C.avifImage
+	cols  int
+	rows  int
+}
+
+// newImageGrid splits the pixels into grid cells and converts them to YUV using the options returned by
+// prepareOptions. ctx is checked before each cell is converted. The grid must be destroyed by the caller.
+func newImageGrid(ctx context.Context, pixels rgbPixels, options Options) (*imageGrid, error) {
+	width := pixels.width
+	height := pixels.height
 
 	if width == 0 || height == 0 {
 		return nil, fmt.Errorf("invalid image dimensions: %dx%d", width, height)
 	}
 
-	// Calculate tile dimensions - use max dimensions supported by SVT-AV1
-	tileWidth := 16384
-	tileHeight := 8704
-
-	// Calculate the number of tiles needed (1x1 for images within limits)
-	cols := (width + tileWidth - 1) / tileWidth
-	rows := (height + tileHeight - 1) / tileHeight
+	grid := &imageGrid{}
+	var cellWidth, cellHeight int
+	grid.cols, grid.rows, cellWidth, cellHeight = gridLayout(width, height, options.GridCellWidth,
+		options.GridCellHeight)
 
 	// Create tiles
-	cellImages, err := createTiles(rgba, tileWidth, tileHeight)
+	var err error
+	grid.cells, err = createTiles(ctx, pixels, cellWidth, cellHeight, options)
 	if err != nil {
 		return nil, err
 	}
 
-	defer func() {
-		for _, img := range cellImages {
-			if img != nil {
-				C.avifImageDestroy(img)
-			}
-		}
-	}()
+	// A grid has the size of the image rounded up to even, as 4:2:0 requires; crop the padding away unless the options
+	// already crop
+	gridWidth, gridHeight := width, height
+	if len(grid.cells) > 1 {
+		gridWidth += width % 2
+		gridHeight += height % 2
+	}
+	if options.Crop == nil && (gridWidth != width || gridHeight != height) {
+		crop := image.Rect(0, 0, width, height)
+		options.Crop = &crop
+	}
+
+	if err = setImageTransform(grid.cells, gridWidth, gridHeight, options); err != nil {
+		grid.destroy()
+		return nil, err
+	}
+
+	return grid, nil
+}
+
+// gridLayout returns the number of columns and rows of the grid an image is split into, and the size of its cells,
+// which are at most maxWidth x maxHeight.
+//
+// The image is divided evenly between the cells, whose size is rounded up to even as 4:2:0 chroma subsampling requires
+// in a grid. The cells of the last column and row only hold the pixels left over, so they may be smaller; libavif
+// allows that, and the grid keeps the size of the image, only rounded up to even when the image has an odd size.
+func gridLayout(width, height, maxWidth, maxHeight int) (cols, rows, cellWidth, cellHeight int) {
+	cols = (width + maxWidth - 1) / maxWidth
+	rows = (height + maxHeight - 1) / maxHeight
+	if cols == 1 && rows == 1 {
+		return 1, 1, width, height
+	}
+
+	cellWidth = (width + cols - 1) / cols
+	cellWidth += cellWidth % 2
+	cellHeight = (height + rows - 1) / rows
+	cellHeight += cellHeight % 2
+
+	// Rounding up may leave nothing for the last column or row
+	cols = (width + cellWidth - 1) / cellWidth
+	rows = (height + cellHeight - 1) / cellHeight
+	return cols, rows, cellWidth, cellHeight
+}
 
+// encode encodes the grid with the speed and quality from the options. The cells are left untouched, so the grid can
+// be encoded again.
+func (g *imageGrid) encode(ctx context.Context, options Options) ([]byte, error) {
 	// Create encoder
-	encoder := C.avifEncoderCreate()
+	encoder := newEncoder(options)
 	if encoder == nil {
 		return nil, fmt.Errorf("failed to create AVIF encoder")
 	}
 	defer C.avifEncoderDestroy(encoder)
 
-	encoder.codecChoice = C.AVIF_CODEC_CHOICE_SVT
-	encoder.speed = C.int(options.Speed)
-	encoder.quality = C.int(options.ColorQuality)
-	encoder.qualityAlpha = C.int(options.AlphaQuality)
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
 
 	// Add the grid of images (1x1 for normal images, NxM for oversized)
-	result := C.avifEncoderAddImageGrid(encoder, C.uint32_t(cols), C.uint32_t(rows),
-		(**C.avifImage)(unsafe.Pointer(&cellImages[0])), C.AVIF_ADD_IMAGE_FLAG_SINGLE)
+	result := C.avifEncoderAddImageGrid(encoder, C.uint32_t(g.cols), C.uint32_t(g.rows),
+		(**C.avifImage)(unsafe.Pointer(&g.cells[0])), C.AVIF_ADD_IMAGE_FLAG_SINGLE)
 
 	if result != C.AVIF_RESULT_OK {
-		errStr := C.GoString(C.get_error_string(result))
-		return nil, fmt.Errorf("failed to add image grid: %s", errStr)
+		return nil, fmt.Errorf("failed to add image grid: %w", encoderError(result, encoder))
+	}
+
+	return finishEncoder(encoder)
+}
+
+// destroy frees the cells of the grid.
+func (g *imageGrid) destroy() {
+	for _, img := range g.cells {
+		if img != nil {
+			C.avifImageDestroy(img)
+		}
+	}
+	g.cells = nil
+}
+
+// newEncoder creates a libavif encoder configured with the speed, quality, tiling and threads from the options. The
+// caller must destroy it.
+func newEncoder(options Options) *C.avifEncoder {
+	encoder := C.avifEncoderCreate()
+	if encoder == nil {
+		return nil
 	}
 
-	// Finish encoding
+	encoder.codecChoice = C.AVIF_CODEC_CHOICE_SVT
+	encoder.speed = C.int(options.Speed)
+	encoder.quality = C.int(options.ColorQuality)
+	encoder.qualityAlpha = C.int(options.AlphaQuality)
+	encoder.tileRowsLog2 = C.int(options.TileRowsLog2)
+	encoder.tileColsLog2 = C.int(options.TileColsLog2)
+	if options.AutoTiling {
+		encoder.autoTiling = C.AVIF_TRUE
+	}
+	encoder.maxThreads = C.int(options.Threads)
+
+	return encoder
+}
+
+// finishEncoder finishes encoding and copies the output into a Go slice.
+func finishEncoder(encoder *C.avifEncoder) ([]byte, error) {
 	var encodedData C.avifRWData
 	encodedData.data = nil
 	encodedData.size = 0
 
-	result = C.avifEncoderFinish(encoder, &encodedData)
+	result := C.avifEncoderFinish(encoder, &encodedData)
 	if result != C.AVIF_RESULT_OK {
-		errStr := C.GoString(C.get_error_string(result))
-		return nil, fmt.Errorf("failed to finish encoding: %s", errStr)
+		return nil, fmt.Errorf("failed to finish encoding: %w", encoderError(result, encoder))
 	}
 	defer C.avifRWDataFree(&encodedData)
 
@@ -153,11 +234,16 @@ func encodeAVIF(rgba image.RGBA, options Options) ([]byte, error) {
 	return data, nil
 }
 
-// createTiles splits the input RGBA image into tiles and converts them to AVIF format.
+// createTiles splits the input RGBA pixels into tiles of at most tileWidth x tileHeight and converts them to AVIF
+// format, stopping early if ctx is done. The tiles of the last column and row hold what is left of the image. When
+// there is more than one tile, 4:2:0 chroma subsampling needs them to have an even size, so a tile of odd width or
+// height is padded by repeating the last column or row of the image.
 // Returns a slice of avifImage pointers that must be freed by the caller.
-func createTiles(rgba image.RGBA, tileWidth, tileHeight int) ([]*C.avifImage, error) {
-	width := rgba.Bounds().Dx()
-	height := rgba.Bounds().Dy()
+func createTiles(ctx context.Context, pixels rgbPixels, tileWidth, tileHeight int, options Options) ([]*C.avifImage,
+	error) {
+	width := pixels.width
+	height := pixels.height
+	bpp := pixels.bytesPerPixel()
 
 	cols := (width + tileWidth - 1) / tileWidth
 	rows := (height + tileHeight - 1) / tileHeight
@@ -166,41 +252,51 @@ func createTiles(rgba image.RGBA, tileWidth, tileHeight int) ([]*C.avifImage, er
 	cellImages := make([]*C.avifImage, 0, cols*rows)
 
 	// Pre-allocate tile buffer once and reuse
-	maxTileSize := tileWidth * tileHeight * 4
-	tileBuffer := make([]byte, maxTileSize)
+	stride := tileWidth * bpp
+	tileBuffer := make([]byte, tileHeight*stride)
 
 	for row := 0; row < rows; row++ {
 		for col := 0; col < cols; col++ {
-			// Calculate tile boundaries
+			// Calculate the part of the tile within the image
 			x0 := col * tileWidth
 			y0 := row * tileHeight
-			x1 := x0 + tileWidth
-			y1 := y0 + tileHeight
-			if x1 > width {
-				x1 = width
-			}
-			if y1 > height {
-				y1 = height
+			visibleWidth := min(tileWidth, width-x0)
+			cellWidth := visibleWidth
+			cellHeight := min(tileHeight, height-y0)
+			if cols*rows > 1 {
+				cellWidth += cellWidth % 2
+				cellHeight += cellHeight % 2
 			}
 
-			tileW := x1 - x0
-			tileH := y1 - y0
+			tile := rgbPixels{
+				pix:           tileBuffer,
+				stride:        stride,
+				width:         cellWidth,
+				height:        cellHeight,
+				depth:         pixels.depth,
+				premultiplied: pixels.premultiplied,
+				gray:          pixels.gray,
+			}
 
-			// Use pre-allocated buffer
-			stride := tileW * 4
-			tileSize := tileH * stride
-			tilePix := tileBuffer[:tileSize]
+			// Copy rows, repeating the last row and column of the image into the padding
+			for y := 0; y < cellHeight; y++ {
+				srcY := min(y0+y, height-1)
+				dst := tile.pix[y*stride : (y+1)*stride]
+				srcOffset := srcY*pixels.stride + x0*bpp
+				copy(dst, pixels.pix[srcOffset:srcOffset+visibleWidth*bpp])
 
-			// Copy rows
-			for y := 0; y < tileH; y++ {
-				srcY := y0 + y
-				dstOffset := y * stride
-				srcOffset := srcY*rgba.Stride + x0*4
-				copy(tilePix[dstOffset:dstOffset+stride], rgba.Pix[srcOffset:srcOffset+stride])
+				last := dst[(visibleWidth-1)*bpp : visibleWidth*bpp]
+				for x := visibleWidth; x < cellWidth; x++ {
+					copy(dst[x*bpp:], last)
+				}
 			}
 
 			// Create and convert tile
-			avifImage, err := createAVIFTile(tilePix, tileW, tileH, stride, col, row)
+			err := ctx.Err()
+			var avifImage *C.avifImage
+			if err == nil {
+				avifImage, err = createAVIFTile(tile, col, row, options)
+			}
 			if err != nil {
 				// Clean up already created tiles
 				for _, img := range cellImages {
@@ -219,12 +315,38 @@ func createTiles(rgba image.RGBA, tileWidth, tileHeight int) ([]*C.avifImage, er
 }
 
 // createAVIFTile creates an avifImage from raw RGBA pixel data.
-func createAVIFTile(pixels []byte, width, height, stride, col, row int) (*C.avifImage, error) {
-	avifImage := C.avifImageCreate(C.uint32_t(width), C.uint32_t(height), 8, C.AVIF_PIXEL_FORMAT_YUV420)
+func createAVIFTile(pixels rgbPixels, col, row int, options Options) (*C.avifImage, error) {
+	avifImage := C.avifImageCreate(C.uint32_t(pixels.width), C.uint32_t(pixels.height), C.uint32_t(options.Depth),
+		C.AVIF_PIXEL_FORMAT_YUV420)
 	if avifImage == nil {
 		return nil, fmt.Errorf("failed to create AVIF image for tile (%d,%d)", col, row)
 	}
 
+	if err := setImageMetadata(avifImage, options); err != nil {
+		C.avifImageDestroy(avifImage)
+		return nil, fmt.Errorf("failed to set metadata for tile (%d,%d): %w", col, row, err)
+	}
+
+	if options.Color != nil {
+		avifImage.colorPrimaries = C.avifColorPrimaries(options.Color.ColorPrimaries)
+		avifImage.transferCharacteristics = C.avifTransferCharacteristics(options.Color.TransferCharacteristics)
+		avifImage.matrixCoefficients = C.avifMatrixCoefficients(options.Color.MatrixCoefficients)
+		avifImage.yuvRange = C.AVIF_RANGE_LIMITED
+		if options.Color.FullRange {
+			avifImage.yuvRange = C.AVIF_RANGE_FULL
+		}
+	}
+
+	// Grayscale samples are the luma plane already, so they skip the RGB to YUV conversion
+	if pixels.gray {
+		avifImage.yuvRange = C.AVIF_RANGE_FULL
+		if err := copyGrayToYUV(avifImage, pixels); err != nil {
+			C.avifImageDestroy(avifImage)
+			return nil, fmt.Errorf("failed to copy gray tile (%d,%d): %w", col, row, err)
+		}
+		return avifImage, nil
+	}
+
 	// Convert to YUV
 	rgb := (*C.avifRGBImage)(C.malloc(C.size_t(unsafe.Sizeof(C.avifRGBImage{}))))
 	if rgb == nil {
@@ -234,105 +356,579 @@ func createAVIFTile(pixels []byte, width, height, stride, col, row int) (*C.avif
 
 	C.avifRGBImageSetDefaults(rgb, avifImage)
 	rgb.format = C.AVIF_RGB_FORMAT_RGBA
-	rgb.depth = 8
-	rgb.pixels = (*C.uint8_t)(unsafe.Pointer(&pixels[0]))
-	rgb.rowBytes = C.uint32_t(stride)
+	rgb.depth = C.uint32_t(pixels.depth)
+	rgb.pixels = (*C.uint8_t)(unsafe.Pointer(&pixels.pix[0]))
+	rgb.rowBytes = C.uint32_t(pixels.stride)
+	if pixels.premultiplied {
+		rgb.alphaPremultiplied = C.AVIF_TRUE
+	}
 
 	result := C.avifImageRGBToYUV(avifImage, rgb)
 	C.free(unsafe.Pointer(rgb))
 
 	if result != C.AVIF_RESULT_OK {
 		C.avifImageDestroy(avifImage)
-		return nil, fmt.Errorf("failed to convert tile (%d,%d) from RGB to YUV", col, row)
+		return nil, fmt.Errorf("failed to convert tile (%d,%d) from RGB to YUV: %w", col, row,
+			resultError(OpConvert, result))
 	}
 
 	return avifImage, nil
 }
 
-// decodeAVIFToRGBA decodes AVIF image data to an RGBA image.
-func decodeAVIFToRGBA(data []byte) (*image.RGBA, error) {
-	if len(data) == 0 {
-		return nil, fmt.Errorf("cannot decode empty data")
+// setImageMetadata attaches the ICC profile, EXIF and XMP payloads from the options to avifImage. libavif copies the
+// data, so the Go slices are not retained.
+func setImageMetadata(avifImage *C.avifImage, options Options) error {
+	if len(options.ICCProfile) > 0 {
+		result := C.avifImageSetProfileICC(avifImage, (*C.uint8_t)(unsafe.Pointer(&options.ICCProfile[0])),
+			C.size_t(len(options.ICCProfile)))
+		if result != C.AVIF_RESULT_OK {
+			return fmt.Errorf("failed to set ICC profile: %w", resultError(OpEncode, result))
+		}
+	}
+
+	if len(options.EXIF) > 0 {
+		result := C.avifImageSetMetadataExif(avifImage, (*C.uint8_t)(unsafe.Pointer(&options.EXIF[0])),
+			C.size_t(len(options.EXIF)))
+		if result != C.AVIF_RESULT_OK {
+			return fmt.Errorf("failed to set EXIF metadata: %w", resultError(OpEncode, result))
+		}
+	}
+
+	if len(options.XMP) > 0 {
+		result := C.avifImageSetMetadataXMP(avifImage, (*C.uint8_t)(unsafe.Pointer(&options.XMP[0])),
+			C.size_t(len(options.XMP)))
+		if result != C.AVIF_RESULT_OK {
+			return fmt.Errorf("failed to set XMP metadata: %w", resultError(OpEncode, result))
+		}
+	}
+
+	return nil
+}
+
+// setImageTransform writes the orientation and crop from the options as irot/imir/clap properties on every cell. The
+// clean aperture is computed against the full image, since libavif applies it to the assembled grid.
+func setImageTransform(cellImages []*C.avifImage, width, height int, options Options) error {
+	var flags C.avifTransformFlags
+	var irot C.avifImageRotation
+	var imir C.avifImageMirror
+	var clap C.avifCleanApertureBox
+
+	if options.Orientation > 1 {
+		orientation := orientations[options.Orientation]
+		if orientation.angle != 0 {
+			flags |= C.AVIF_TRANSFORM_IROT
+			irot.angle = C.uint8_t(orientation.angle)
+		}
+		if orientation.axis >= 0 {
+			flags |= C.AVIF_TRANSFORM_IMIR
+			imir.axis = C.uint8_t(orientation.axis)
+		}
+	}
+
+	if options.Crop != nil {
+		rect := C.avifCropRect{
+			x:      C.uint32_t(options.Crop.Min.X),
+			y:      C.uint32_t(options.Crop.Min.Y),
+			width:  C.uint32_t(options.Crop.Dx()),
+			height: C.uint32_t(options.Crop.Dy()),
+		}
+
+		var diag C.avifDiagnostics
+		valid := C.avifCleanApertureBoxFromCropRect(&clap, &rect, C.uint32_t(width), C.uint32_t(height), &diag)
+		if valid == C.AVIF_FALSE {
+			return fmt.Errorf("invalid crop rectangle %v: %s", *options.Crop, C.GoString(&diag.error[0]))
+		}
+		flags |= C.AVIF_TRANSFORM_CLAP
+	}
+
+	for _, img := range cellImages {
+		img.transformFlags = flags
+		img.irot = irot
+		img.imir = imir
+		img.clap = clap
+	}
+
+	return nil
+}
+
+// transformInfo reads the irot, imir and clap properties of a decoded avifImage.
+func transformInfo(avifImg *C.avifImage) Transform {
+	angle := 0
+	if avifImg.transformFlags&C.AVIF_TRANSFORM_IROT != 0 {
+		angle = int(avifImg.irot.angle)
+	}
+	axis := -1
+	if avifImg.transformFlags&C.AVIF_TRANSFORM_IMIR != 0 {
+		axis = int(avifImg.imir.axis)
+	}
+
+	transform := Transform{Orientation: orientationFromIrotImir(angle, axis)}
+
+	if avifImg.transformFlags&C.AVIF_TRANSFORM_CLAP != 0 {
+		var rect C.avifCropRect
+		var diag C.avifDiagnostics
+		valid := C.avifCropRectFromCleanApertureBox(&rect, &avifImg.clap, avifImg.width, avifImg.height, &diag)
+		if valid == C.AVIF_TRUE {
+			crop := image.Rect(int(rect.x), int(rect.y), int(rect.x+rect.width), int(rect.y+rect.height))
+			transform.Crop = &crop
+		}
+	}
+
+	return transform
+}
+
+// rwDataBytes copies the contents of an avifRWData into a Go slice, returning nil when it is empty.
+func rwDataBytes(data C.avifRWData) []byte {
+	if data.size == 0 {
+		return nil
+	}
+	return C.GoBytes(unsafe.Pointer(data.data), C.int(data.size))
+}
+
+// colorInfo reads the CICP colour description of a decoded avifImage.
+func colorInfo(avifImg *C.avifImage) ColorInfo {
+	return ColorInfo{
+		ColorPrimaries:          ColorPrimaries(avifImg.colorPrimaries),
+		TransferCharacteristics: TransferCharacteristics(avifImg.transferCharacteristics),
+		MatrixCoefficients:      MatrixCoefficients(avifImg.matrixCoefficients),
+		FullRange:               avifImg.yuvRange == C.AVIF_RANGE_FULL,
+	}
+}
+
+// copyGrayToYUV allocates the planes of avifImage and fills the luma plane with the gray samples, rescaling them to the
+// image depth, and the chroma planes with the neutral value, so every matrix decodes them to the same gray.
+func copyGrayToYUV(avifImage *C.avifImage, pixels rgbPixels) error {
+	if result := C.avifImageAllocatePlanes(avifImage, C.AVIF_PLANES_YUV); result != C.AVIF_RESULT_OK {
+		return resultError(OpConvert, result)
+	}
+
+	depth := int(avifImage.depth)
+	rowBytes := int(avifImage.yuvRowBytes[0])
+
+	for y := 0; y < pixels.height; y++ {
+		src := pixels.pix[y*pixels.stride : y*pixels.stride+pixels.width*pixels.bytesPerPixel()]
+		dstPtr := unsafe.Add(unsafe.Pointer(avifImage.yuvPlanes[0]), y*rowBytes)
+
+		if depth == 8 {
+			copy(unsafe.Slice((*byte)(dstPtr), pixels.width), src)
+			continue
+		}
+
+		dst := unsafe.Slice((*uint16)(dstPtr), pixels.width)
+		for x := range dst {
+			if pixels.depth == 8 {
+				// Replicate the high bits so 0xff maps to the maximum value at the target depth
+				v := uint16(src[x])
+				dst[x] = v<<(depth-8) | v>>(16-depth)
+			} else {
+				dst[x] = binary.NativeEndian.Uint16(src[x*2:]) >> (16 - depth)
+			}
+		}
+	}
+
+	var info C.avifPixelFormatInfo
+	C.avifGetPixelFormatInfo(avifImage.yuvFormat, &info)
+	chromaWidth := (pixels.width + int(info.chromaShiftX)) >> info.chromaShiftX
+	chromaHeight := (pixels.height + int(info.chromaShiftY)) >> info.chromaShiftY
+	neutral := uint16(1) << (depth - 1)
+
+	for plane := 1; plane < 3; plane++ {
+		rowBytes := int(avifImage.yuvRowBytes[plane])
+		for y := 0; y < chromaHeight; y++ {
+			dstPtr := unsafe.Add(unsafe.Pointer(avifImage.yuvPlanes[plane]), y*rowBytes)
+			if depth == 8 {
+				dst := unsafe.Slice((*byte)(dstPtr), chromaWidth)
+				for x := range dst {
+					dst[x] = byte(neutral)
+				}
+				continue
+			}
+
+			dst := unsafe.Slice((*uint16)(dstPtr), chromaWidth)
+			for x := range dst {
+				dst[x] = neutral
+			}
+		}
+	}
+
+	return nil
+}
+
+// decodeAVIF decodes AVIF image data to the closest Go image type: image.Gray for monochrome images without alpha,
+// image.NRGBA for images with alpha and image.RGBA otherwise. The orientation and crop stored in the file are applied.
+func decodeAVIF(ctx context.Context, input *decoderInput) (image.Image, error) {
+	return decodeAVIFImage(ctx, input, DecodeOptions{}, avifImageToUprightImage)
+}
+
+// decodeAVIFToRGBA decodes encoded AVIF data to an RGBA image as stored, without applying its orientation and crop, so
+// it can be compared with the pixels it was encoded from.
+func decodeAVIFToRGBA(ctx context.Context, data []byte) (*image.RGBA, error) {
+	input := memoryInput(data)
+	defer input.close()
+
+	return decodeAVIFImage(ctx, input, DecodeOptions{}, avifImageToRGBA)
+}
+
+// decodeAVIFWithMetadata decodes AVIF image data like decodeAVIF and also returns the metadata stored with the image.
+// The image type is selected by the Output option, and the orientation and crop are only applied when IgnoreTransforms
+// is not set.
+func decodeAVIFWithMetadata(ctx context.Context, input *decoderInput, options DecodeOptions) (image.Image, *Metadata,
+	error) {
+	var metadata *Metadata
+	img, err := decodeAVIFImage(ctx, input, options, func(avifImg *C.avifImage) (image.Image, error) {
+		metadata = &Metadata{
+			Color:      colorInfo(avifImg),
+			ICCProfile: rwDataBytes(avifImg.icc),
+			EXIF:       rwDataBytes(avifImg.exif),
+			XMP:        rwDataBytes(avifImg.xmp),
+			Transform:  transformInfo(avifImg),
+		}
+
+		return avifImageToOutput(avifImg, options)
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return img, metadata, nil
+}
+
+// avifImageToUprightImage converts a decoded avifImage like avifImageToImage and applies its orientation and crop.
+func avifImageToUprightImage(avifImg *C.avifImage) (image.Image, error) {
+	img, err := avifImageToImage(avifImg)
+	if err != nil {
+		return nil, err
+	}
+	return applyTransform(img, transformInfo(avifImg)), nil
+}
+
+// avifImageToOutput converts a decoded avifImage to the image types selected by options.Output, and applies its
+// orientation and crop unless options.IgnoreTransforms is set.
+func avifImageToOutput(avifImg *C.avifImage, options DecodeOptions) (image.Image, error) {
+	var img image.Image
+	var err error
+	if options.Output == OutputNative {
+		img, err = avifImageToNative(avifImg, !options.IgnoreTransforms)
+	} else {
+		img, err = avifImageToImage(avifImg)
+	}
+	if err != nil || options.IgnoreTransforms {
+		return img, err
+	}
+	return applyTransform(img, transformInfo(avifImg)), nil
+}
+
+// avifImageToImage converts a decoded avifImage to image.Gray for monochrome images without alpha, image.NRGBA for
+// images with alpha and image.RGBA otherwise.
+//
+// AVIF stores straight (non-premultiplied) alpha, which image.NRGBA holds as is. Opaque images are the same in both
+// representations, so they use image.RGBA, which is faster to draw.
+func avifImageToImage(avifImg *C.avifImage) (image.Image, error) {
+	switch {
+	case avifImg.alphaPlane != nil:
+		return avifImageToNRGBA(avifImg)
+	case avifImg.yuvFormat == C.AVIF_PIXEL_FORMAT_YUV400:
+		return avifImageToGray(avifImg)
+	default:
+		return avifImageToRGBA(avifImg)
+	}
+}
+
+// newDecoder creates a libavif decoder configured with the given options. The caller must destroy it.
+func newDecoder(options DecodeOptions) *C.avifDecoder {
+	decoder := C.avifDecoderCreate()
+	if decoder == nil {
+		return nil
+	}
+
+	// Force libavif to use the dav1d backend.
+	decoder.codecChoice = C.AVIF_CODEC_CHOICE_DAV1D
+	if options.IgnoreEXIF {
+		decoder.ignoreExif = C.AVIF_TRUE
+	}
+	if options.IgnoreXMP {
+		decoder.ignoreXMP = C.AVIF_TRUE
+	}
+	decoder.strictFlags = C.AVIF_STRICT_ENABLED &^ C.avifStrictFlags(options.RelaxedChecks)
+	decoder.maxThreads = C.int(options.Threads)
+	if options.Threads == 0 {
+		decoder.maxThreads = C.int(runtime.NumCPU())
+	}
+
+	// libavif checks these while parsing, before reading any frame or grid cell. The options only lower its defaults
+	if options.MaxPixels > 0 {
+		decoder.imageSizeLimit = C.uint32_t(options.MaxPixels)
+	}
+	if options.MaxDimension > 0 && options.MaxDimension < int(decoder.imageDimensionLimit) {
+		decoder.imageDimensionLimit = C.uint32_t(options.MaxDimension)
+	}
+	if options.MaxImageCount > 0 && options.MaxImageCount < int(decoder.imageCountLimit) {
+		decoder.imageCountLimit = C.uint32_t(options.MaxImageCount)
+	}
+
+	return decoder
+}
+
+// checkDecodeOptions validates the limits in the decode options.
+func checkDecodeOptions(options DecodeOptions) error {
+	if options.MaxInputSize < 0 || options.MaxPixels < 0 || options.MaxDimension < 0 || options.MaxImageCount < 0 {
+		return fmt.Errorf("decode limits must not be negative")
+	}
+	if options.Threads < 0 {
+		return fmt.Errorf("threads must not be negative")
 	}
+	if options.MaxPixels > C.AVIF_DEFAULT_IMAGE_SIZE_LIMIT {
+		return fmt.Errorf("max pixels must not exceed %d", C.AVIF_DEFAULT_IMAGE_SIZE_LIMIT)
+	}
+	if options.Output != OutputRGBA && options.Output != OutputNative {
+		return fmt.Errorf("unknown output format %d", options.Output)
+	}
+	if options.RelaxedChecks&^StrictAll != 0 {
+		return fmt.Errorf("unknown strict flags %#x", uint32(options.RelaxedChecks&^StrictAll))
+	}
+	return nil
+}
 
-	// Allocate C memory and copy data.
-	cData := C.CBytes(data)
-	defer C.free(cData)
+// enforceLimits checks the image described by a parsed decoder against the limits in the options.
+//
+// newDecoder hands the same limits to libavif, which rejects most files exceeding them while parsing. This check
+// covers the images libavif does not validate itself, and reports the offending values.
+func enforceLimits(decoder *C.avifDecoder, options DecodeOptions) error {
+	width := int(decoder.image.width)
+	height := int(decoder.image.height)
+
+	if options.MaxDimension > 0 && (width > options.MaxDimension || height > options.MaxDimension) {
+		return fmt.Errorf("%w: %dx%d, maximum dimension %d", ErrImageTooLarge, width, height, options.MaxDimension)
+	}
+	if options.MaxPixels > 0 && width*height > options.MaxPixels {
+		return fmt.Errorf("%w: %dx%d, maximum %d pixels", ErrImageTooLarge, width, height, options.MaxPixels)
+	}
+	if options.MaxImageCount > 0 && int(decoder.imageCount) > options.MaxImageCount {
+		return fmt.Errorf("%w: %d images, maximum %d", ErrTooManyImages, int(decoder.imageCount),
+			options.MaxImageCount)
+	}
+	return nil
+}
 
-	var decoder *C.avifDecoder
-	var result C.avifResult
-	avifImg := C.decode_avif_image((*C.uint8_t)(cData), C.size_t(len(data)), &decoder, &result)
-	if avifImg == nil {
-		errStr := C.GoString(C.get_error_string(result))
-		return nil, fmt.Errorf("failed to decode AVIF image: %s", errStr)
+// parseError returns the error for a failed avifDecoderParse call. libavif reports files exceeding the decoder's
+// limits as generic parse failures, so they are told apart by their diagnostic message and reported as
+// ErrImageTooLarge or ErrTooManyImages instead.
+func parseError(result C.avifResult, decoder *C.avifDecoder) error {
+	err := decoderError(OpParse, result, decoder)
+	switch {
+	case strings.Contains(err.Detail, "imageCountLimit"):
+		return fmt.Errorf("%w: %s", ErrTooManyImages, err.Detail)
+	case strings.Contains(err.Detail, "dimensions are too large"):
+		return fmt.Errorf("%w: %s", ErrImageTooLarge, err.Detail)
+	}
+	return err
+}
+
+// decodeAVIFImage decodes the first image in the AVIF input and hands it to convert. The decoder is destroyed once
+// convert returns, so convert must copy everything it needs out of the avifImage.
+//
+// ctx is checked before parsing, decoding and converting the image. Decoding is a single native call, so it runs to
+// completion once started.
+func decodeAVIFImage[T image.Image](ctx context.Context, input *decoderInput, options DecodeOptions,
+	convert func(*C.avifImage) (T, error)) (T, error) {
+	var zero T
+	if err := ctx.Err(); err != nil {
+		return zero, err
+	}
+
+	decoder, err := parseInput(input, options)
+	if err != nil {
+		return zero, fmt.Errorf("failed to decode AVIF image: %w", err)
 	}
 	defer C.avifDecoderDestroy(decoder)
 
+	if err := enforceLimits(decoder, options); err != nil {
+		return zero, err
+	}
+	if err := ctx.Err(); err != nil {
+		return zero, err
+	}
+	if result := C.avifDecoderNextImage(decoder); result != C.AVIF_RESULT_OK {
+		if err := input.err(); err != nil {
+			return zero, fmt.Errorf("failed to read AVIF data: %w", err)
+		}
+		return zero, fmt.Errorf("failed to decode AVIF image: %w", decoderError(OpDecode, result, decoder))
+	}
+	if err := ctx.Err(); err != nil {
+		return zero, err
+	}
+
+	return convert(decoder.image)
+}
+
+// avifImageToRGBA converts a decoded avifImage to an RGBA image, premultiplying the colour by the alpha channel as
+// image.RGBA requires.
+func avifImageToRGBA(avifImg *C.avifImage) (*image.RGBA, error) {
+	img := image.NewRGBA(image.Rect(0, 0, int(avifImg.width), int(avifImg.height)))
+	if err := convertToRGBA(avifImg, img.Pix, img.Stride, 8, true); err != nil {
+		return nil, err
+	}
+	return img, nil
+}
+
+// avifImageToNRGBA converts a decoded avifImage to an NRGBA image, keeping the straight alpha stored in the file.
+func avifImageToNRGBA(avifImg *C.avifImage) (*image.NRGBA, error) {
+	img := image.NewNRGBA(image.Rect(0, 0, int(avifImg.width), int(avifImg.height)))
+	if err := convertToRGBA(avifImg, img.Pix, img.Stride, 8, false); err != nil {
+		return nil, err
+	}
+	return img, nil
+}
+
+// convertToRGBA converts a decoded avifImage to RGBA samples of the given depth (8 or 16) and copies them into pix, a
+// buffer of width x height pixels with the given stride. 16-bit samples are stored big-endian, like Go's 16-bit image
+// types expect. With premultiplied set, the colour is multiplied by the alpha channel.
+func convertToRGBA(avifImg *C.avifImage, pix []byte, stride int, depth int, premultiplied bool) error {
 	// Set up an avifRGBImage struct to hold the converted image.
 	var rgb C.avifRGBImage
 	C.avifRGBImageSetDefaults(&rgb, avifImg)
 	rgb.format = C.AVIF_RGB_FORMAT_RGBA
-	rgb.depth = 8 // 8-bit per channel
+	rgb.depth = C.uint32_t(depth)
+	if premultiplied {
+		rgb.alphaPremultiplied = C.AVIF_TRUE
+	}
 
 	// Allocate pixel buffer for the RGB data.
-	if C.avifRGBImageAllocatePixels(&rgb) != C.AVIF_RESULT_OK {
-		return nil, fmt.Errorf("failed to allocate RGB pixels")
+	if result := C.avifRGBImageAllocatePixels(&rgb); result != C.AVIF_RESULT_OK {
+		return fmt.Errorf("failed to allocate RGB pixels: %w", resultError(OpConvert, result))
 	}
 	defer C.avifRGBImageFreePixels(&rgb)
 
 	// Convert the image from YUV to RGB.
-	result = C.avifImageYUVToRGB(avifImg, &rgb)
+	result := C.avifImageYUVToRGB(avifImg, &rgb)
 	if result != C.AVIF_RESULT_OK {
-		errStr := C.GoString(C.get_error_string(result))
-		return nil, fmt.Errorf("failed to convert image to RGB: %s", errStr)
+		return fmt.Errorf("failed to convert image to RGB: %w", resultError(OpConvert, result))
 	}
 
 	width := int(avifImg.width)
 	height := int(avifImg.height)
-	img := image.NewRGBA(image.Rect(0, 0, width, height))
 	rowBytes := int(rgb.rowBytes)
+	rowSize := 4 * width * depth / 8
 
 	// Copy the pixel data row by row into the Go image using direct pointer access.
 	// This avoids the extra allocation from C.GoBytes for the entire buffer.
 	for y := 0; y < height; y++ {
 		srcPtr := unsafe.Add(unsafe.Pointer(rgb.pixels), y*rowBytes)
-		dstOffset := y * img.Stride
-		copy(img.Pix[dstOffset:dstOffset+4*width],
-			unsafe.Slice((*byte)(srcPtr), 4*width))
+		dst := pix[y*stride : y*stride+rowSize]
+		if depth == 8 {
+			copy(dst, unsafe.Slice((*byte)(srcPtr), rowSize))
+			continue
+		}
+
+		for i, v := range unsafe.Slice((*uint16)(srcPtr), 4*width) {
+			binary.BigEndian.PutUint16(dst[i*2:], v)
+		}
 	}
 
-	return img, nil
+	return nil
 }
 
-// decodeConfig reads enough of the data to determine the image's configuration (dimensions, etc.).
+// avifImageToGray converts a decoded monochrome avifImage to a Gray image.
 //
-// This is a lightweight operation that only parses the header.
-func decodeConfig(data []byte) (image.Config, error) {
-	if len(data) == 0 {
-		return image.Config{}, fmt.Errorf("failed to get AVIF image config: empty data")
+// Full range 8-bit luma is copied as is; any other depth or range goes through libavif's YUV to RGB conversion so the
+// values match what avifImageToRGBA would return.
+func avifImageToGray(avifImg *C.avifImage) (*image.Gray, error) {
+	width := int(avifImg.width)
+	height := int(avifImg.height)
+	img := image.NewGray(image.Rect(0, 0, width, height))
+
+	if avifImg.depth == 8 && avifImg.yuvRange == C.AVIF_RANGE_FULL {
+		rowBytes := int(avifImg.yuvRowBytes[0])
+		for y := 0; y < height; y++ {
+			srcPtr := unsafe.Add(unsafe.Pointer(avifImg.yuvPlanes[0]), y*rowBytes)
+			copy(img.Pix[y*img.Stride:y*img.Stride+width], unsafe.Slice((*byte)(srcPtr), width))
+		}
+		return img, nil
 	}
 
-	// Use C.CBytes for safer memory handling
-	cData := C.CBytes(data)
-	defer C.free(cData)
+	rgba, err := avifImageToRGBA(avifImg)
+	if err != nil {
+		return nil, err
+	}
 
-	var width, height C.uint32_t
-	var result C.avifResult
-	C.get_avif_config((*C.uint8_t)(cData), C.size_t(len(data)), &width, &height, &result)
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			img.Pix[y*img.Stride+x] = rgba.Pix[y*rgba.Stride+x*4]
+		}
+	}
+
+	return img, nil
+}
+
+// parseInput creates a decoder and parses the container of the input, without decoding any pixel. The caller must
+// destroy the decoder, and close the input only after that.
+func parseInput(input *decoderInput, options DecodeOptions) (*C.avifDecoder, error) {
+	if input.size == 0 {
+		return nil, fmt.Errorf("empty data: %w", ErrInvalidData)
+	}
+
+	decoder := newDecoder(options)
+	if decoder == nil {
+		return nil, fmt.Errorf("failed to create AVIF decoder")
+	}
+
+	if err := input.attach(decoder); err != nil {
+		C.avifDecoderDestroy(decoder)
+		return nil, err
+	}
 
+	result := C.avifDecoderParse(decoder)
 	if result != C.AVIF_RESULT_OK {
-		errStr := C.GoString(C.get_error_string(result))
-		return image.Config{}, fmt.Errorf("failed to get AVIF image config: %s", errStr)
+		err := input.err()
+		if err != nil {
+			err = fmt.Errorf("failed to read AVIF data: %w", err)
+		} else {
+			err = parseError(result, decoder)
+		}
+		C.avifDecoderDestroy(decoder)
+		return nil, err
 	}
 
+	return decoder, nil
+}
+
+// decodeConfig reads enough of the input to determine the image's configuration (dimensions, etc.).
+//
+// This is a lightweight operation that only parses the header; seekable inputs only read the header boxes.
+func decodeConfig(input *decoderInput) (image.Config, error) {
+	decoder, err := parseInput(input, DecodeOptions{})
+	if err != nil {
+		return image.Config{}, fmt.Errorf("failed to get AVIF image config: %w", err)
+	}
+	defer C.avifDecoderDestroy(decoder)
+
+	width, height := displaySize(decoder.image)
 	if width == 0 || height == 0 {
 		return image.Config{}, fmt.Errorf("invalid image dimensions: %dx%d", width, height)
 	}
 
-	// We assume an RGBA color model for simplicity.
 	return image.Config{
-		ColorModel: color.RGBAModel,
-		Width:      int(width),
-		Height:     int(height),
+		ColorModel: colorModel(decoder),
+		Width:      width,
+		Height:     height,
 	}, nil
 }
+
+// displaySize returns the dimensions of a parsed avifImage as displayed, after its crop and rotation.
+func displaySize(avifImg *C.avifImage) (int, int) {
+	var width, height C.uint32_t
+	C.get_display_size(avifImg, &width, &height)
+	return int(width), int(height)
+}
+
+// colorModel returns the colour model of the image Decode returns for a parsed decoder, matching avifImageToImage.
+func colorModel(decoder *C.avifDecoder) color.Model {
+	switch {
+	case decoder.alphaPresent == C.AVIF_TRUE:
+		return color.NRGBAModel
+	case decoder.image.yuvFormat == C.AVIF_PIXEL_FORMAT_YUV400:
+		return color.GrayModel
+	default:
+		return color.RGBAModel
+	}
+}
@@ -0,0 +1,290 @@
+package avif
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+)
+
+// errBoxNotFound is returned by the box readers when the container lacks a box they need.
+var errBoxNotFound = errors.New("box not found")
+
+// box is an ISOBMFF box header, locating the box payload within the file.
+type box struct {
+	kind   string
+	offset int64
+	size   int64
+}
+
+// readBoxes lists the boxes stored between offset and end.
+func readBoxes(r io.ReaderAt, offset, end int64) ([]box, error) {
+	var boxes []box
+	for offset+8 <= end {
+		var header [16]byte
+		if _, err := r.ReadAt(header[:8], offset); err != nil {
+			return nil, err
+		}
+
+		size := int64(binary.BigEndian.Uint32(header[:4]))
+		headerSize := int64(8)
+		switch size {
+		case 0:
+			size = end - offset
+		case 1:
+			if _, err := r.ReadAt(header[8:16], offset+8); err != nil {
+				return nil, err
+			}
+			size = int64(binary.BigEndian.Uint64(header[8:16]))
+			headerSize = 16
+		}
+		// Compare against the space left rather than offset+size, which a 64-bit largesize can overflow
+		if size < headerSize || size > end-offset {
+			return nil, errors.New("invalid box size")
+		}
+
+		boxes = append(boxes, box{kind: string(header[4:8]), offset: offset + headerSize, size: size - headerSize})
+		offset += size
+	}
+	return boxes, nil
+}
+
+// findBox returns the first box of the given kind.
+func findBox(boxes []box, kind string) (box, error) {
+	for _, b := range boxes {
+		if b.kind == kind {
+			return b, nil
+		}
+	}
+	return box{}, errBoxNotFound
+}
+
+// hasSyncSampleTable reports whether the first track of an image sequence lists its sync samples in an stss box. A
+// track without one only holds sync samples. It returns errBoxNotFound for files without tracks.
+func hasSyncSampleTable(r io.ReaderAt, size int64) (bool, error) {
+	boxes, err := readBoxes(r, 0, size)
+	if err != nil {
+		return false, err
+	}
+	for _, kind := range []string{"moov", "trak", "mdia", "minf", "stbl"} {
+		parent, err := findBox(boxes, kind)
+		if err != nil {
+			return false, err
+		}
+		if boxes, err = readBoxes(r, parent.offset, parent.offset+parent.size); err != nil {
+			return false, err
+		}
+	}
+
+	_, err = findBox(boxes, "stss")
+	return err == nil, nil
+}
+
+// payloadReader reads the big-endian fields of a box payload, remembering the first error.
+type payloadReader struct {
+	data []byte
+	err  error
+}
+
+// uint returns the next field of the given size in bytes (0, 1, 2, 4 or 8); a size of 0 reads nothing and returns 0.
+func (p *payloadReader) uint(size int) uint64 {
+	if p.err != nil || size == 0 {
+		return 0
+	}
+	if len(p.data) < size {
+		p.err = io.ErrUnexpectedEOF
+		return 0
+	}
+
+	var v uint64
+	for _, b := range p.data[:size] {
+		v = v<<8 | uint64(b)
+	}
+	p.data = p.data[size:]
+	return v
+}
+
+// readPayload reads the payload of a box into memory.
+func readPayload(r io.ReaderAt, b box) (*payloadReader, error) {
+	data := make([]byte, b.size)
+	if _, err := r.ReadAt(data, b.offset); err != nil {
+		return nil, err
+	}
+	return &payloadReader{data: data}, nil
+}
+
+// readGridLayout returns the number of columns and rows of cells of the primary item, when it is a grid. It returns
+// errBoxNotFound for images that are not grids.
+func readGridLayout(r io.ReaderAt, size int64) (int, int, error) {
+	top, err := readBoxes(r, 0, size)
+	if err != nil {
+		return 0, 0, err
+	}
+	meta, err := findBox(top, "meta")
+	if err != nil {
+		return 0, 0, err
+	}
+	// meta is a full box: skip its version and flags
+	children, err := readBoxes(r, meta.offset+4, meta.offset+meta.size)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	primary, err := readPrimaryItem(r, children)
+	if err != nil {
+		return 0, 0, err
+	}
+	if kind, err := readItemType(r, children, primary); err != nil || kind != "grid" {
+		if err == nil {
+			err = errBoxNotFound
+		}
+		return 0, 0, err
+	}
+
+	grid, err := readItemData(r, children, primary, 8)
+	if err != nil {
+		return 0, 0, err
+	}
+	// version, flags, rows_minus_one, columns_minus_one, then the output dimensions
+	return int(grid[3]) + 1, int(grid[2]) + 1, nil
+}
+
+// readPrimaryItem returns the ID of the primary item, from the pitm box.
+func readPrimaryItem(r io.ReaderAt, children []box) (uint64, error) {
+	pitm, err := findBox(children, "pitm")
+	if err != nil {
+		return 0, err
+	}
+	p, err := readPayload(r, pitm)
+	if err != nil {
+		return 0, err
+	}
+
+	version := p.uint(4) >> 24
+	id := p.uint(itemIDSize(version, 1))
+	return id, p.err
+}
+
+// readItemType returns the type of an item, from its infe box in the iinf box.
+func readItemType(r io.ReaderAt, children []box, id uint64) (string, error) {
+	iinf, err := findBox(children, "iinf")
+	if err != nil {
+		return "", err
+	}
+	p, err := readPayload(r, iinf)
+	if err != nil {
+		return "", err
+	}
+
+	version := p.uint(4) >> 24
+	p.uint(itemIDSize(version, 1)) // entry_count
+	if p.err != nil {
+		return "", p.err
+	}
+
+	entries, err := readBoxes(r, iinf.offset+iinf.size-int64(len(p.data)), iinf.offset+iinf.size)
+	if err != nil {
+		return "", err
+	}
+	for _, entry := range entries {
+		if entry.kind != "infe" {
+			continue
+		}
+		infe, err := readPayload(r, entry)
+		if err != nil {
+			return "", err
+		}
+
+		// Item types only exist from version 2 on
+		infeVersion := infe.uint(4) >> 24
+		if infeVersion < 2 {
+			continue
+		}
+		itemID := infe.uint(itemIDSize(infeVersion, 3))
+		infe.uint(2) // item_protection_index
+		kind := infe.uint(4)
+		if infe.err != nil {
+			return "", infe.err
+		}
+		if itemID == id {
+			return string(binary.BigEndian.AppendUint32(nil, uint32(kind))), nil
+		}
+	}
+	return "", errBoxNotFound
+}
+
+// readItemData reads the first length bytes of an item, located by its iloc entry, either in the file or in the idat
+// box.
+func readItemData(r io.ReaderAt, children []box, id uint64, length int) ([]byte, error) {
+	iloc, err := findBox(children, "iloc")
+	if err != nil {
+		return nil, err
+	}
+	p, err := readPayload(r, iloc)
+	if err != nil {
+		return nil, err
+	}
+
+	version := p.uint(4) >> 24
+	sizes := p.uint(2)
+	offsetSize := int(sizes >> 12)
+	lengthSize := int(sizes >> 8 & 0xf)
+	baseOffsetSize := int(sizes >> 4 & 0xf)
+	indexSize := 0
+	if version == 1 || version == 2 {
+		indexSize = int(sizes & 0xf)
+	}
+
+	count := p.uint(itemIDSize(version, 2))
+	for i := uint64(0); i < count && p.err == nil; i++ {
+		itemID := p.uint(itemIDSize(version, 2))
+		method := uint64(0)
+		if version == 1 || version == 2 {
+			method = p.uint(2) & 0xf
+		}
+		p.uint(2) // data_reference_index
+		base := p.uint(baseOffsetSize)
+		extents := p.uint(2)
+
+		var offset uint64
+		for e := uint64(0); e < extents; e++ {
+			p.uint(indexSize)
+			extentOffset := p.uint(offsetSize)
+			p.uint(lengthSize)
+			if e == 0 {
+				offset = base + extentOffset
+			}
+		}
+		if p.err != nil || itemID != id {
+			continue
+		}
+
+		start := int64(offset)
+		if method == 1 {
+			idat, err := findBox(children, "idat")
+			if err != nil {
+				return nil, err
+			}
+			start += idat.offset
+		}
+
+		data := make([]byte, length)
+		if _, err = r.ReadAt(data, start); err != nil {
+			return nil, err
+		}
+		return data, nil
+	}
+
+	if p.err != nil {
+		return nil, p.err
+	}
+	return nil, errBoxNotFound
+}
+
+// itemIDSize returns the size in bytes of item IDs and counts in a full box of the given version: 2 bytes below
+// minVersion, 4 bytes from it on.
+func itemIDSize(version uint64, minVersion uint64) int {
+	if version < minVersion {
+		return 2
+	}
+	return 4
+}
@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"image"
 	"os"
+	"os/signal"
 	"time"
 
 	"github.com/DND-IT/avif-go"
@@ -76,7 +77,7 @@ func main() {
 					}
 
 					now := time.Now()
-					img, info, err := encodeAvif(input, output, options)
+					img, info, err := encodeAvif(ctx, input, output, options)
 					duration := time.Since(now)
 
 					if err == nil {
@@ -104,7 +105,7 @@ func main() {
 					}
 
 					now := time.Now()
-					img, info, err := decodeAvif(input, output)
+					img, info, err := decodeAvif(ctx, input, output)
 					duration := time.Since(now)
 
 					if err == nil {
@@ -120,7 +121,11 @@ func main() {
 		},
 	}
 
-	if err := cmd.Run(context.Background(), os.Args); err != nil {
+	// Interrupting the program cancels the context, which stops encoding or decoding at the next checkpoint
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	if err := cmd.Run(ctx, os.Args); err != nil {
 		msg := fmt.Sprintf("🧨 %v", err)
 		fmt.Println(red.Render(msg))
 	}
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"image"
 	"image/gif"
@@ -25,7 +26,7 @@ import (
 
 var ValidImageTypes = []string{".bmp", ".gif", ".jpg", ".jpeg", ".png", ".tiff"}
 
-func encodeAvif(input, output string, options *avif.Options) (image.Image, os.FileInfo, error) {
+func encodeAvif(ctx context.Context, input, output string, options *avif.Options) (image.Image, os.FileInfo, error) {
 	inputFile, err := os.Open(input)
 	if err != nil {
 		return nil, nil, err
@@ -45,7 +46,7 @@ func encodeAvif(input, output string, options *avif.Options) (image.Image, os.Fi
 
 	defer outputFile.Close()
 
-	err = avif.Encode(outputFile, img, options)
+	err = avif.EncodeContext(ctx, outputFile, img, options)
 	if err != nil {
 		return nil, nil, err
 	}
@@ -58,7 +59,7 @@ func encodeAvif(input, output string, options *avif.Options) (image.Image, os.Fi
 	return img, info, nil
 }
 
-func decodeAvif(input, output string) (image.Image, os.FileInfo, error) {
+func decodeAvif(ctx context.Context, input, output string) (image.Image, os.FileInfo, error) {
 	ext := strings.ToLower(filepath.Ext(output))
 	if !slices.Contains(ValidImageTypes, ext) {
 		return nil, nil, fmt.Errorf("invalid output file type: %s", ext)
@@ -71,7 +72,7 @@ func decodeAvif(input, output string) (image.Image, os.FileInfo, error) {
 
 	defer inputFile.Close()
 
-	img, _, err := image.Decode(inputFile)
+	img, _, err := avif.DecodeContext(ctx, inputFile, nil)
 	if err != nil {
 		return nil, nil, err
 	}
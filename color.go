@@ -0,0 +1,61 @@
+package avif
+
+// ColorPrimaries identifies the chromaticity of the red, green and blue primaries and the white point, as defined in
+// ITU-T H.273.
+type ColorPrimaries uint16
+
+const (
+	ColorPrimariesBT709       ColorPrimaries = 1 // Also used by sRGB
+	ColorPrimariesUnspecified ColorPrimaries = 2
+	ColorPrimariesBT601       ColorPrimaries = 6
+	ColorPrimariesBT2020      ColorPrimaries = 9 // Also used by BT.2100
+	ColorPrimariesDCIP3       ColorPrimaries = 11
+	ColorPrimariesDisplayP3   ColorPrimaries = 12 // SMPTE EG 432-1
+)
+
+// TransferCharacteristics identifies the opto-electronic transfer function of the samples, as defined in ITU-T H.273.
+type TransferCharacteristics uint16
+
+const (
+	TransferCharacteristicsBT709       TransferCharacteristics = 1
+	TransferCharacteristicsUnspecified TransferCharacteristics = 2
+	TransferCharacteristicsBT601       TransferCharacteristics = 6
+	TransferCharacteristicsLinear      TransferCharacteristics = 8
+	TransferCharacteristicsSRGB        TransferCharacteristics = 13
+	TransferCharacteristicsPQ          TransferCharacteristics = 16 // SMPTE ST 2084, BT.2100 PQ
+	TransferCharacteristicsHLG         TransferCharacteristics = 18 // ARIB STD-B67, BT.2100 HLG
+)
+
+// MatrixCoefficients identifies the matrix used to derive luma and chroma from RGB, as defined in ITU-T H.273.
+type MatrixCoefficients uint16
+
+const (
+	MatrixCoefficientsIdentity    MatrixCoefficients = 0
+	MatrixCoefficientsBT709       MatrixCoefficients = 1
+	MatrixCoefficientsUnspecified MatrixCoefficients = 2
+	MatrixCoefficientsBT601       MatrixCoefficients = 6
+	MatrixCoefficientsBT2020NCL   MatrixCoefficients = 9
+)
+
+// ColorInfo holds the CICP (coding-independent code points) colour description of an image, plus its YUV range.
+//   - ColorPrimaries: The colour primaries the RGB values refer to.
+//   - TransferCharacteristics: The transfer function applied to the RGB values.
+//   - MatrixCoefficients: The matrix used to convert between RGB and YUV.
+//   - FullRange: Whether YUV samples use the full range (0-255 at 8 bits) instead of the limited "studio" range.
+type ColorInfo struct {
+	ColorPrimaries          ColorPrimaries
+	TransferCharacteristics TransferCharacteristics
+	MatrixCoefficients      MatrixCoefficients
+	FullRange               bool
+}
+
+// DefaultColorInfo returns the colour description used when Options.Color is nil: unspecified primaries and transfer
+// characteristics, BT.601 matrix coefficients and full range. Browsers treat such images as sRGB.
+func DefaultColorInfo() ColorInfo {
+	return ColorInfo{
+		ColorPrimaries:          ColorPrimariesUnspecified,
+		TransferCharacteristics: TransferCharacteristicsUnspecified,
+		MatrixCoefficients:      MatrixCoefficientsBT601,
+		FullRange:               true,
+	}
+}
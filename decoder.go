@@ -3,6 +3,7 @@
 package avif
 
 import (
+	"context"
 	"fmt"
 	"image"
 	"io"
@@ -18,24 +19,160 @@ func init() {
 
 // Decode reads AVIF image data from the provided io.Reader and decodes it into an image.Image.
 //
-// It returns the decoded image or an error if the decoding process fails.
+// Monochrome (4:0:0) images without alpha are returned as *image.Gray, images with alpha as *image.NRGBA (AVIF stores
+// straight, non-premultiplied alpha) and opaque images as *image.RGBA. The orientation and crop stored in the file are
+// applied, so the image is returned upright.
+//
+// Readers implementing io.Seeker (such as *os.File or *bytes.Reader) are read on demand from their current position,
+// so the data is never held in memory twice; other readers are read entirely first.
+//
+// It returns the decoded image or an error if the decoding process fails. Failures reported by libavif wrap an *Error,
+// which matches ErrInvalidData, ErrUnsupported or ErrOutOfMemory with errors.Is depending on the cause.
 func Decode(reader io.Reader) (image.Image, error) {
-	data, err := io.ReadAll(reader)
+	input, err := newDecoderInput(reader, 0)
 	if err != nil {
 		return nil, fmt.Errorf("failed to decode AVIF data: %w", err)
 	}
-	return decodeAVIFToRGBA(data)
+	defer input.close()
+
+	return decodeAVIF(context.Background(), input)
+}
+
+// StrictFlags selects libavif's strict conformance checks, which reject files written by some non-conforming encoders.
+type StrictFlags uint32
+
+const (
+	// StrictPixiRequired requires the pixel information property (pixi) on image items. libheif 1.11.0 and older do not
+	// write it.
+	StrictPixiRequired StrictFlags = 1 << iota
+	// StrictClapValid requires the clean aperture property (clap) to describe a valid crop rectangle.
+	StrictClapValid
+	// StrictAlphaISPERequired requires the image spatial extents property (ispe) on alpha items. avif-serialize 0.7.3
+	// and older do not write it.
+	StrictAlphaISPERequired
+	// StrictAll selects every strict check.
+	StrictAll = StrictPixiRequired | StrictClapValid | StrictAlphaISPERequired
+)
+
+// OutputFormat selects the Go image types decoded images are returned as.
+type OutputFormat int
+
+const (
+	// OutputRGBA converts every image to 8 bits per channel: *image.Gray for monochrome images without alpha,
+	// *image.NRGBA for images with alpha and *image.RGBA otherwise.
+	OutputRGBA OutputFormat = iota
+	// OutputNative returns the type closest to how the image is stored, skipping the colour conversion where possible:
+	//   - *image.YCbCr for opaque 8-bit 4:4:4, 4:2:2 and 4:2:0 images using full range BT.601 coefficients (the ones Go
+	//     and JPEG use, and the encoder's default), with the planes copied as is. Images that must be rotated or
+	//     mirrored are converted like OutputRGBA instead.
+	//   - *image.Gray for 8-bit monochrome images and *image.Gray16 for 10 and 12-bit ones, without alpha.
+	//   - *image.RGBA64 for opaque 10 and 12-bit images and *image.NRGBA64 for those with alpha.
+	// Everything else is returned like OutputRGBA.
+	OutputNative
+)
+
+// DecodeOptions represent the configuration options for decoding an AVIF image.
+//   - IgnoreEXIF: Skips reading the EXIF payload; Metadata.EXIF is always nil (default false).
+//   - IgnoreXMP: Skips reading the XMP packet; Metadata.XMP is always nil (default false).
+//   - IgnoreTransforms: Returns the image as stored, without applying its orientation and crop; they are still
+//     reported in Metadata.Transform (default false).
+//   - MaxInputSize: Maximum size of the AVIF data in bytes. Larger inputs fail with ErrInputTooLarge, and readers that
+//     cannot seek are not read past the limit (default 0, unlimited).
+//   - MaxPixels: Maximum number of pixels (width x height) of the image, up to libavif's limit of 16384x16384. Larger
+//     images fail with ErrImageTooLarge once the header is parsed, before any pixel is decoded (default 0, libavif's
+//     limit).
+//   - MaxDimension: Maximum width or height of the image. Larger images fail with ErrImageTooLarge once the header is
+//     parsed (default 0, libavif's limit of 32768).
+//   - MaxImageCount: Maximum number of frames in an image sequence. Longer sequences fail with ErrTooManyImages once
+//     the header is parsed (default 0, libavif's limit of 12 hours at 60 frames per second).
+//   - RelaxedChecks: Strict conformance checks to skip, to accept files from encoders that do not write them
+//     (default 0, every check is enforced).
+//   - Output: The Go image types the image is returned as (default OutputRGBA).
+//   - Threads: Maximum number of threads dav1d uses to decode the image (default 0, runtime.NumCPU()). Like
+//     Options.Threads, the limit applies to each call, so decoding from many goroutines multiplies it.
+type DecodeOptions struct {
+	IgnoreEXIF       bool
+	IgnoreXMP        bool
+	IgnoreTransforms bool
+	MaxInputSize     int64
+	MaxPixels        int
+	MaxDimension     int
+	MaxImageCount    int
+	RelaxedChecks    StrictFlags
+	Output           OutputFormat
+	Threads          int
+}
+
+// Metadata holds the information stored alongside the pixels of an AVIF image.
+//   - Color: The CICP colour description and YUV range of the image.
+//   - ICCProfile: The embedded ICC colour profile, or nil if the image has none.
+//   - EXIF: The raw EXIF payload, or nil if the image has none.
+//   - XMP: The raw XMP packet, or nil if the image has none.
+//   - Transform: The orientation and crop stored in the image. Unless DecodeOptions.IgnoreTransforms is set, they have
+//     already been applied to the returned image.
+type Metadata struct {
+	Color      ColorInfo
+	ICCProfile []byte
+	EXIF       []byte
+	XMP        []byte
+	Transform  Transform
+}
+
+// DecodeWithMetadata reads AVIF image data from the provided io.Reader and decodes it like Decode, additionally
+// returning the metadata stored in the file.
+//
+// It returns the decoded image and its metadata, or an error if the decoding process fails.
+func DecodeWithMetadata(reader io.Reader) (image.Image, *Metadata, error) {
+	return DecodeWithOptions(reader, nil)
+}
+
+// DecodeWithOptions reads AVIF image data from the provided io.Reader and decodes it using the given options. If
+// options is nil, default values are used.
+//
+// Set the limits in the options when decoding untrusted data: they are checked before the image is decoded, so a small
+// file claiming huge dimensions fails early instead of allocating memory for them.
+//
+// It returns the decoded image and its metadata, or an error if the decoding process fails.
+func DecodeWithOptions(reader io.Reader, options *DecodeOptions) (image.Image, *Metadata, error) {
+	return DecodeContext(context.Background(), reader, options)
+}
+
+// DecodeContext decodes AVIF image data like DecodeWithOptions, stopping when ctx is done. The context is checked
+// before parsing, decoding and converting the image; a step already running in libavif completes first. The native
+// decoder is freed either way.
+//
+// It returns the decoded image and its metadata, or an error if the decoding process fails. If ctx is done, the error
+// is ctx.Err().
+func DecodeContext(ctx context.Context, reader io.Reader, options *DecodeOptions) (image.Image, *Metadata, error) {
+	if options == nil {
+		options = &DecodeOptions{}
+	}
+	if err := checkDecodeOptions(*options); err != nil {
+		return nil, nil, err
+	}
+
+	input, err := newDecoderInput(reader, options.MaxInputSize)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to decode AVIF data: %w", err)
+	}
+	defer input.close()
+
+	return decodeAVIFWithMetadata(ctx, input, *options)
 }
 
 // DecodeConfig reads the configuration of an AVIF image from the provided io.Reader.
 //
+// Readers implementing io.Seeker are read on demand, so only the header boxes are read; other readers are read
+// entirely first.
+//
 // It returns an image.Config containing the width, height, and color model of the image, or an error if the
 // configuration cannot be determined.
 func DecodeConfig(reader io.Reader) (image.Config, error) {
-	data, err := io.ReadAll(reader)
+	input, err := newDecoderInput(reader, 0)
 	if err != nil {
 		return image.Config{}, fmt.Errorf("failed get config of AVIF data: %w", err)
 	}
+	defer input.close()
 
-	return decodeConfig(data)
+	return decodeConfig(input)
 }
@@ -1,21 +1,67 @@
 package avif
 
 import (
+	"context"
+	"encoding/binary"
 	"fmt"
 	"image"
 	"image/draw"
 	"io"
+	"runtime"
 )
 
-// Options represent the configuration options for encoding an AVIF image.
+// Options represent the configuration options for encoding an AVIF image. Images are always encoded with 4:2:0 chroma
+// subsampling, the only one SVT-AV1 supports, so there is no option to keep full colour resolution. For the same
+// reason, image.Gray and image.Gray16 inputs are stored as 4:2:0 rather than 4:0:0 monochrome: their samples are copied
+// straight into a full range luma plane, with neutral chroma planes.
 //   - Speed: Controls the encoding speed, from 0-10. Higher values result in faster encoding but lower quality
 //     (default 6).
 //   - AlphaQuality: Specifies the quality of the alpha channel (transparency), from 0-100 (default 60).
-//   - ColorQuality: Specifies the quality of the color channels, from 0-100 (default 60).
+//   - ColorQuality: Specifies the quality of the color channels, from 0-100 (default 60). Even 100 is not lossless:
+//     lossless AVIF needs 4:4:4 with identity matrix coefficients, which SVT-AV1, the only encoder built in, cannot
+//     encode.
+//   - Depth: Bit depth of the encoded image, either 8 or 10 (default 8). With 10, image.RGBA64, image.NRGBA64 and
+//     image.Gray16 inputs are read at full 16-bit precision instead of being reduced to 8 bits. SVT-AV1 cannot encode
+//     12-bit images, so 12 fails with ErrUnsupported.
+//   - Color: CICP colour description written to the image (default DefaultColorInfo). image.Gray and image.Gray16
+//     inputs are always written in full range.
+//   - ICCProfile: ICC colour profile embedded in the image (default none). The bytes are copied, not validated.
+//   - EXIF: Raw EXIF payload embedded in the image, starting with its TIFF header (default none).
+//   - XMP: Raw XMP packet embedded in the image (default none).
+//   - Orientation: EXIF orientation (1-8) of the image, written as irot/imir properties so viewers display it rotated
+//     and/or mirrored (default 1, upright). The pixels themselves are stored unchanged.
+//   - Crop: Rectangle, relative to the image bounds, written as a clean aperture (clap) so viewers only display that
+//     region (default nil, the whole image). The pixels outside it are still stored.
+//   - TileRowsLog2, TileColsLog2: Split each frame into 2^n rows and columns of AV1 tiles, from 0 to 6, which
+//     decoders can process in parallel at a small cost in compression (default 0, a single tile).
+//   - AutoTiling: Lets libavif choose the tiling from the image size, ignoring TileRowsLog2 and TileColsLog2
+//     (default false).
+//   - GridCellWidth, GridCellHeight: Maximum size of the cells the image is split into when it is stored as a grid of
+//     separately encoded images. Both must be even, from 128 up to SVT-AV1's limit of 16384x8704; smaller cells let
+//     large images be decoded in parallel and incrementally (default 0, SVT-AV1's limit, so only larger images use a
+//     grid). The image is divided evenly between the cells. A grid needs an even width and height for 4:2:0 chroma
+//     subsampling, so images of odd size are padded by a pixel, which a clean aperture crops away.
+//   - Threads: Maximum number of threads libavif and SVT-AV1 use to encode the image (default 0, runtime.NumCPU()).
+//     Every Encode call starts its own threads, so n calls running in parallel use up to n times as many; when
+//     encoding from many goroutines, divide the CPUs between them, or set 1 and let the goroutines provide the
+//     parallelism.
 type Options struct {
-	Speed        int
-	AlphaQuality int
-	ColorQuality int
+	Speed          int
+	AlphaQuality   int
+	ColorQuality   int
+	Depth          int
+	Color          *ColorInfo
+	ICCProfile     []byte
+	EXIF           []byte
+	XMP            []byte
+	Orientation    int
+	Crop           *image.Rectangle
+	TileRowsLog2   int
+	TileColsLog2   int
+	AutoTiling     bool
+	GridCellWidth  int
+	GridCellHeight int
+	Threads        int
 }
 
 // Encode encodes an image into the AVIF format and writes it to the provided writer.
@@ -28,34 +74,228 @@ type Options struct {
 // Returns:
 //   - An error if encoding or writing fails, otherwise nil.
 func Encode(writer io.Writer, img image.Image, options *Options) error {
-	// Convert the image to RGBA
-	bounds := img.Bounds()
-	rgba := image.NewRGBA(bounds)
-	draw.Draw(rgba, rgba.Bounds(), img, bounds.Min, draw.Src)
+	return EncodeContext(context.Background(), writer, img, options)
+}
+
+// EncodeContext encodes an image like Encode, stopping when ctx is done. The context is checked before each grid cell
+// is converted and before the image is encoded. The encoding itself, of every cell of a grid at once, is a single
+// SVT-AV1 call that cannot be interrupted: cancelling ctx while it runs only takes effect once it returns, which for
+// large images at a low Speed can take a long time. The native encoder and images are freed either way, and nothing is
+// written.
+//
+// Returns:
+//   - ctx.Err() if ctx is done before encoding completes, an error if encoding or writing fails, otherwise nil.
+func EncodeContext(ctx context.Context, writer io.Writer, img image.Image, options *Options) error {
+	opts, err := prepareOptions(img, options)
+	if err != nil {
+		return err
+	}
+
+	data, err := encodeAVIF(ctx, newPixels(img, opts), opts)
+	if err != nil {
+		return err
+	}
+
+	if _, err = writer.Write(data); err != nil {
+		return fmt.Errorf("failed to write AVIF image: %v", err)
+	}
 
+	return nil
+}
+
+// prepareOptions validates the options for encoding img and returns a copy with the defaults resolved. If options is
+// nil, default values are used.
+func prepareOptions(img image.Image, options *Options) (Options, error) {
 	// Set default values for options if they are not set
 	if options == nil {
 		options = &Options{Speed: 6, AlphaQuality: 60, ColorQuality: 60}
 	}
 
 	if options.Speed < 0 || options.Speed > 10 {
-		return fmt.Errorf("speed must be between 0 and 10")
+		return Options{}, fmt.Errorf("speed must be between 0 and 10")
 	}
 	if options.AlphaQuality < 0 || options.AlphaQuality > 100 {
-		return fmt.Errorf("alpha quality must be between 0 and 100")
+		return Options{}, fmt.Errorf("alpha quality must be between 0 and 100")
 	}
 	if options.ColorQuality < 0 || options.ColorQuality > 100 {
-		return fmt.Errorf("color quality must be between 0 and 100")
+		return Options{}, fmt.Errorf("color quality must be between 0 and 100")
 	}
 
-	data, err := encodeAVIF(*rgba, *options)
-	if err != nil {
-		return err
+	if options.Orientation < 0 || options.Orientation > 8 {
+		return Options{}, fmt.Errorf("orientation must be between 1 and 8")
 	}
 
-	if _, err = writer.Write(data); err != nil {
-		return fmt.Errorf("failed to write AVIF image: %v", err)
+	if options.TileRowsLog2 < 0 || options.TileRowsLog2 > 6 || options.TileColsLog2 < 0 || options.TileColsLog2 > 6 {
+		return Options{}, fmt.Errorf("tile rows and columns log2 must be between 0 and 6")
+	}
+	if options.GridCellWidth != 0 && (options.GridCellWidth < 128 || options.GridCellWidth > maxTileWidth ||
+		options.GridCellWidth%2 != 0) {
+		return Options{}, fmt.Errorf("grid cell width must be an even number between 128 and %d", maxTileWidth)
+	}
+	if options.GridCellHeight != 0 && (options.GridCellHeight < 128 || options.GridCellHeight > maxTileHeight ||
+		options.GridCellHeight%2 != 0) {
+		return Options{}, fmt.Errorf("grid cell height must be an even number between 128 and %d", maxTileHeight)
+	}
+	if options.Threads < 0 {
+		return Options{}, fmt.Errorf("threads must not be negative")
 	}
 
-	return nil
+	opts := *options
+	if opts.Crop != nil {
+		size := img.Bounds().Size()
+		if opts.Crop.Empty() || !opts.Crop.In(image.Rectangle{Max: size}) {
+			return Options{}, fmt.Errorf("crop rectangle %v must be non-empty and within the image size %v", *opts.Crop,
+				size)
+		}
+	}
+
+	if opts.GridCellWidth == 0 {
+		opts.GridCellWidth = maxTileWidth
+	}
+	if opts.GridCellHeight == 0 {
+		opts.GridCellHeight = maxTileHeight
+	}
+	if opts.Threads == 0 {
+		opts.Threads = runtime.NumCPU()
+	}
+
+	if opts.Depth == 0 {
+		opts.Depth = 8
+	}
+	if opts.Depth == 12 {
+		return Options{}, fmt.Errorf("12-bit encoding is not supported by the SVT-AV1 encoder: %w", ErrUnsupported)
+	}
+	if opts.Depth != 8 && opts.Depth != 10 {
+		return Options{}, fmt.Errorf("depth must be 8 or 10")
+	}
+
+	// libavif leaves the matrix coefficients unspecified, so write the documented default instead
+	if opts.Color == nil {
+		colorInfo := DefaultColorInfo()
+		opts.Color = &colorInfo
+	}
+
+	return opts, nil
+}
+
+// newPixels converts img into the pixel layout handed to libavif, using the options returned by prepareOptions:
+// the luma plane for grayscale sources, and interleaved RGBA for everything else.
+func newPixels(img image.Image, options Options) rgbPixels {
+	if isGray(img) {
+		return newGrayPixels(img, options.Depth)
+	}
+	return newRGBPixels(img, options.Depth)
+}
+
+// newRGBPixels converts an image into the interleaved RGBA layout expected by libavif.
+//
+// High bit depth sources (image.RGBA64 and image.NRGBA64) are read directly into 16-bit samples when the target depth
+// is above 8 bits; everything else is flattened to 8-bit RGBA. image.NRGBA keeps its straight alpha, while other
+// sources are premultiplied like image.RGBA and libavif divides the alpha back out.
+func newRGBPixels(img image.Image, depth int) rgbPixels {
+	if depth > 8 {
+		switch src := img.(type) {
+		case *image.RGBA64:
+			return rgbPixelsFrom16(src.Pix, src.Stride, src.Rect, true)
+		case *image.NRGBA64:
+			return rgbPixelsFrom16(src.Pix, src.Stride, src.Rect, false)
+		}
+	}
+
+	bounds := img.Bounds()
+	if src, ok := img.(*image.NRGBA); ok {
+		// Already in the layout libavif expects, and the tiles are copied out of it anyway
+		return rgbPixels{
+			pix:    src.Pix[src.PixOffset(bounds.Min.X, bounds.Min.Y):],
+			stride: src.Stride,
+			width:  bounds.Dx(),
+			height: bounds.Dy(),
+			depth:  8,
+		}
+	}
+
+	// Convert the image to RGBA
+	rgba := image.NewRGBA(bounds)
+	draw.Draw(rgba, rgba.Bounds(), img, bounds.Min, draw.Src)
+
+	return rgbPixels{
+		pix:    rgba.Pix,
+		stride: rgba.Stride,
+		width:  bounds.Dx(),
+		height: bounds.Dy(),
+		depth:  8,
+		// Opaque pixels are the same either way, so libavif can skip dividing the alpha back out
+		premultiplied: !rgba.Opaque(),
+	}
+}
+
+// rgbPixelsFrom16 copies big-endian 16-bit RGBA samples from a Go image buffer into native-endian 16-bit samples.
+func rgbPixelsFrom16(src []byte, srcStride int, rect image.Rectangle, premultiplied bool) rgbPixels {
+	width := rect.Dx()
+	height := rect.Dy()
+	stride := width * 8
+	pix := make([]byte, stride*height)
+
+	for y := 0; y < height; y++ {
+		srcRow := src[y*srcStride : y*srcStride+width*8]
+		dstRow := pix[y*stride : (y+1)*stride]
+
+		for i := 0; i < width*4; i++ {
+			binary.NativeEndian.PutUint16(dstRow[i*2:], uint16(srcRow[i*2])<<8|uint16(srcRow[i*2+1]))
+		}
+	}
+
+	return rgbPixels{
+		pix:           pix,
+		stride:        stride,
+		width:         width,
+		height:        height,
+		depth:         16,
+		premultiplied: premultiplied,
+	}
+}
+
+// isGray reports whether img is one of the single-channel grayscale image types.
+func isGray(img image.Image) bool {
+	switch img.(type) {
+	case *image.Gray, *image.Gray16:
+		return true
+	}
+	return false
+}
+
+// newGrayPixels copies an image.Gray or image.Gray16 into single-channel samples for the luma plane, skipping the RGB
+// to YUV conversion.
+//
+// image.Gray16 keeps its 16-bit samples (in native byte order) when the target depth is above 8 bits.
+func newGrayPixels(img image.Image, depth int) rgbPixels {
+	width := img.Bounds().Dx()
+	height := img.Bounds().Dy()
+
+	switch src := img.(type) {
+	case *image.Gray:
+		return rgbPixels{pix: src.Pix, stride: src.Stride, width: width, height: height, depth: 8, gray: true}
+
+	case *image.Gray16:
+		if depth == 8 {
+			pix := make([]byte, width*height)
+			for y := 0; y < height; y++ {
+				for x := 0; x < width; x++ {
+					pix[y*width+x] = src.Pix[y*src.Stride+x*2]
+				}
+			}
+			return rgbPixels{pix: pix, stride: width, width: width, height: height, depth: 8, gray: true}
+		}
+
+		pix := make([]byte, width*height*2)
+		for y := 0; y < height; y++ {
+			for x := 0; x < width; x++ {
+				s := src.Pix[y*src.Stride+x*2:]
+				binary.NativeEndian.PutUint16(pix[(y*width+x)*2:], uint16(s[0])<<8|uint16(s[1]))
+			}
+		}
+		return rgbPixels{pix: pix, stride: width * 2, width: width, height: height, depth: 16, gray: true}
+	}
+
+	panic("avif: newGrayPixels called with a non-grayscale image")
 }
@@ -0,0 +1,130 @@
+package avif
+
+/*
+#include <avif/avif.h>
+*/
+import "C"
+import (
+	"errors"
+)
+
+// Error categories matched by the *Error values returned when libavif fails. Use errors.Is to check for them, for
+// instance to tell files that cannot be decoded from internal failures.
+var (
+	// ErrInvalidData is matched by errors caused by malformed, truncated or corrupt AVIF data.
+	ErrInvalidData = errors.New("invalid AVIF data")
+	// ErrUnsupported is matched by errors caused by valid AVIF features that libavif or its codecs do not support.
+	ErrUnsupported = errors.New("unsupported AVIF feature")
+	// ErrOutOfMemory is matched by errors caused by a failed memory allocation.
+	ErrOutOfMemory = errors.New("out of memory")
+)
+
+// Errors returned when an input exceeds the limits set in DecodeOptions. They are wrapped with the offending values,
+// so use errors.Is to check for them.
+var (
+	// ErrInputTooLarge is returned when the AVIF data is larger than DecodeOptions.MaxInputSize.
+	ErrInputTooLarge = errors.New("AVIF data exceeds the maximum input size")
+	// ErrImageTooLarge is returned when the image is larger than DecodeOptions.MaxPixels or DecodeOptions.MaxDimension.
+	ErrImageTooLarge = errors.New("AVIF image exceeds the maximum dimensions")
+	// ErrTooManyImages is returned when the file holds more frames than DecodeOptions.MaxImageCount.
+	ErrTooManyImages = errors.New("AVIF image exceeds the maximum image count")
+)
+
+// ErrTargetUnreachable is returned when an image cannot be encoded within the requested target at any quality.
+var ErrTargetUnreachable = errors.New("AVIF image cannot be encoded within the target")
+
+// Op identifies the stage of encoding or decoding that failed.
+type Op int
+
+const (
+	// OpParse is parsing the AVIF container.
+	OpParse Op = iota + 1
+	// OpDecode is decoding the AV1 payload of an image.
+	OpDecode
+	// OpConvert is converting pixels between RGB and YUV.
+	OpConvert
+	// OpEncode is encoding an image or writing the AVIF container.
+	OpEncode
+)
+
+// String returns the name of the operation.
+func (op Op) String() string {
+	switch op {
+	case OpParse:
+		return "parse"
+	case OpDecode:
+		return "decode"
+	case OpConvert:
+		return "convert"
+	case OpEncode:
+		return "encode"
+	default:
+		return "unknown"
+	}
+}
+
+// Result is a libavif result code (avifResult).
+type Result int
+
+// String returns libavif's description of the result code.
+func (r Result) String() string {
+	return C.GoString(C.avifResultToString(C.avifResult(r)))
+}
+
+// Error is returned when a libavif call fails. It is usually wrapped with the context of the call, so use errors.As
+// to get it.
+//   - Op: The stage that failed.
+//   - Result: The libavif result code.
+//   - Detail: libavif's diagnostic message, which often pinpoints the offending box or property; empty if none.
+type Error struct {
+	Op     Op
+	Result Result
+	Detail string
+}
+
+// Error returns the description of the result code, followed by the diagnostic message if there is one.
+func (e *Error) Error() string {
+	if e.Detail == "" {
+		return e.Result.String()
+	}
+	return e.Result.String() + ": " + e.Detail
+}
+
+// Is reports whether the result code belongs to the category of target, one of ErrInvalidData, ErrUnsupported and
+// ErrOutOfMemory.
+func (e *Error) Is(target error) bool {
+	switch target {
+	case ErrInvalidData:
+		switch C.avifResult(e.Result) {
+		case C.AVIF_RESULT_INVALID_FTYP, C.AVIF_RESULT_NO_CONTENT, C.AVIF_RESULT_BMFF_PARSE_FAILED,
+			C.AVIF_RESULT_MISSING_IMAGE_ITEM, C.AVIF_RESULT_DECODE_COLOR_FAILED, C.AVIF_RESULT_DECODE_ALPHA_FAILED,
+			C.AVIF_RESULT_COLOR_ALPHA_SIZE_MISMATCH, C.AVIF_RESULT_ISPE_SIZE_MISMATCH,
+			C.AVIF_RESULT_INVALID_EXIF_PAYLOAD, C.AVIF_RESULT_INVALID_IMAGE_GRID, C.AVIF_RESULT_TRUNCATED_DATA,
+			C.AVIF_RESULT_DECODE_GAIN_MAP_FAILED, C.AVIF_RESULT_INVALID_TONE_MAPPED_IMAGE:
+			return true
+		}
+	case ErrUnsupported:
+		switch C.avifResult(e.Result) {
+		case C.AVIF_RESULT_UNSUPPORTED_DEPTH, C.AVIF_RESULT_NOT_IMPLEMENTED:
+			return true
+		}
+	case ErrOutOfMemory:
+		return C.avifResult(e.Result) == C.AVIF_RESULT_OUT_OF_MEMORY
+	}
+	return false
+}
+
+// resultError returns the error for a failed libavif call that has no diagnostics.
+func resultError(op Op, result C.avifResult) *Error {
+	return &Error{Op: op, Result: Result(result)}
+}
+
+// decoderError returns the error for a failed call on decoder, with the decoder's diagnostic message.
+func decoderError(op Op, result C.avifResult, decoder *C.avifDecoder) *Error {
+	return &Error{Op: op, Result: Result(result), Detail: C.GoString(&decoder.diag.error[0])}
+}
+
+// encoderError returns the error for a failed call on encoder, with the encoder's diagnostic message.
+func encoderError(result C.avifResult, encoder *C.avifEncoder) *Error {
+	return &Error{Op: OpEncode, Result: Result(result), Detail: C.GoString(&encoder.diag.error[0])}
+}
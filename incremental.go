@@ -0,0 +1,243 @@
+package avif
+
+/*
+#include <stdlib.h>
+#include <string.h>
+#include <avif/avif.h>
+
+// An avifIO over a growing in-memory buffer. Reads past the bytes received so far return AVIF_RESULT_WAITING_ON_IO
+// until the input is marked complete.
+typedef struct incremental_io {
+    avifIO io; // Must be the first member, libavif only sees this part
+    uint8_t * data;
+    size_t size;
+    size_t capacity;
+    avifBool complete;
+} incremental_io;
+
+static avifResult incremental_io_read(struct avifIO * io, uint32_t readFlags, uint64_t offset, size_t size, avifROData * out) {
+    incremental_io * self = (incremental_io *)io;
+    if (readFlags != 0) {
+        return AVIF_RESULT_IO_ERROR;
+    }
+
+    if (offset > self->size) {
+        return self->complete ? AVIF_RESULT_IO_ERROR : AVIF_RESULT_WAITING_ON_IO;
+    }
+
+    uint64_t available = self->size - offset;
+    if (size > available) {
+        if (!self->complete) {
+            return AVIF_RESULT_WAITING_ON_IO;
+        }
+        size = (size_t)available;
+    }
+
+    out->data = self->data + offset;
+    out->size = size;
+    return AVIF_RESULT_OK;
+}
+
+static void incremental_io_destroy(struct avifIO * io) {
+    incremental_io * self = (incremental_io *)io;
+    free(self->data);
+    free(self);
+}
+
+static incremental_io * incremental_io_create(void) {
+    incremental_io * self = (incremental_io *)calloc(1, sizeof(incremental_io));
+    if (self == NULL) {
+        return NULL;
+    }
+
+    self->io.destroy = incremental_io_destroy;
+    self->io.read = incremental_io_read;
+    // The buffer may move when it grows, so libavif must copy what it keeps
+    self->io.persistent = AVIF_FALSE;
+    return self;
+}
+
+// Appends bytes to the buffer, growing it as needed. Returns AVIF_FALSE if the memory cannot be allocated.
+static avifBool incremental_io_append(incremental_io * self, const uint8_t * data, size_t size) {
+    if (self->size + size > self->capacity) {
+        size_t capacity = self->capacity ? self->capacity : 65536;
+        while (capacity < self->size + size) {
+            capacity *= 2;
+        }
+
+        uint8_t * grown = (uint8_t *)realloc(self->data, capacity);
+        if (grown == NULL) {
+            return AVIF_FALSE;
+        }
+        self->data = grown;
+        self->capacity = capacity;
+    }
+
+    memcpy(self->data + self->size, data, size);
+    self->size += size;
+    return AVIF_TRUE;
+}
+*/
+import "C"
+import (
+	"fmt"
+	"image"
+	"unsafe"
+)
+
+// IncrementalDecoder decodes an AVIF image while its bytes are still arriving.
+//
+// Bytes are fed with Write as they come in, and Decode decodes as much of the image as the data received so far
+// allows. For grid images, which are split into cells, rows become available one cell row at a time, so large images
+// can be processed before the whole file has arrived. Close must be called to free the native decoder. An
+// IncrementalDecoder is not safe for concurrent use.
+type IncrementalDecoder struct {
+	decoder *C.avifDecoder
+	io      *C.incremental_io
+	options DecodeOptions
+	parsed  bool
+	done    bool
+}
+
+// NewIncrementalDecoder creates an IncrementalDecoder with the given options. If options is nil, default values are
+// used.
+//
+// The limits in the options are enforced as the data arrives: Write fails once MaxInputSize is exceeded, and Decode
+// fails as soon as the parsed header exceeds the other limits. IgnoreTransforms has no effect.
+//
+// It returns the decoder or an error if the options are invalid or the native decoder cannot be created.
+func NewIncrementalDecoder(options *DecodeOptions) (*IncrementalDecoder, error) {
+	if options == nil {
+		options = &DecodeOptions{}
+	}
+	if err := checkDecodeOptions(*options); err != nil {
+		return nil, err
+	}
+
+	decoder := newDecoder(*options)
+	if decoder == nil {
+		return nil, fmt.Errorf("failed to create AVIF decoder")
+	}
+
+	io := C.incremental_io_create()
+	if io == nil {
+		C.avifDecoderDestroy(decoder)
+		return nil, fmt.Errorf("failed to create AVIF incremental reader")
+	}
+
+	// The decoder takes ownership of the reader and destroys it with itself
+	C.avifDecoderSetIO(decoder, &io.io)
+	decoder.allowIncremental = C.AVIF_TRUE
+
+	return &IncrementalDecoder{decoder: decoder, io: io, options: *options}, nil
+}
+
+// Write appends the next bytes of the AVIF data. It implements io.Writer.
+func (d *IncrementalDecoder) Write(p []byte) (int, error) {
+	if d.decoder == nil {
+		return 0, fmt.Errorf("incremental decoder is closed")
+	}
+	if d.io.complete == C.AVIF_TRUE {
+		return 0, fmt.Errorf("cannot write after CloseWrite")
+	}
+	if len(p) == 0 {
+		return 0, nil
+	}
+	if limit := d.options.MaxInputSize; limit > 0 && int64(d.io.size)+int64(len(p)) > limit {
+		return 0, fmt.Errorf("%w: more than %d bytes", ErrInputTooLarge, limit)
+	}
+
+	if C.incremental_io_append(d.io, (*C.uint8_t)(unsafe.Pointer(&p[0])), C.size_t(len(p))) == C.AVIF_FALSE {
+		return 0, fmt.Errorf("failed to allocate memory for AVIF data")
+	}
+
+	return len(p), nil
+}
+
+// CloseWrite marks the end of the AVIF data. Afterward, Decode reports truncated data as an error instead of waiting
+// for more bytes.
+func (d *IncrementalDecoder) CloseWrite() {
+	if d.decoder != nil {
+		d.io.complete = C.AVIF_TRUE
+	}
+}
+
+// Decode decodes as much of the image as the data written so far allows.
+//
+// It returns the number of rows, counted from the top of the image, that are fully decoded, or an error if the data is
+// invalid. Waiting for more data is not an error: Decode simply returns the same row count until more bytes arrive.
+func (d *IncrementalDecoder) Decode() (int, error) {
+	if d.decoder == nil {
+		return 0, fmt.Errorf("incremental decoder is closed")
+	}
+
+	if !d.parsed {
+		result := C.avifDecoderParse(d.decoder)
+		if result == C.AVIF_RESULT_WAITING_ON_IO {
+			return 0, nil
+		}
+		if result != C.AVIF_RESULT_OK {
+			return 0, fmt.Errorf("failed to parse AVIF image: %w", parseError(result, d.decoder))
+		}
+		if err := enforceLimits(d.decoder, d.options); err != nil {
+			return 0, err
+		}
+		d.parsed = true
+	}
+
+	if !d.done {
+		result := C.avifDecoderNextImage(d.decoder)
+		switch result {
+		case C.AVIF_RESULT_OK:
+			d.done = true
+		case C.AVIF_RESULT_WAITING_ON_IO:
+		default:
+			return 0, fmt.Errorf("failed to decode AVIF image: %w", decoderError(OpDecode, result, d.decoder))
+		}
+	}
+
+	return d.DecodedRows(), nil
+}
+
+// DecodedRows returns the number of rows decoded by the last call to Decode.
+func (d *IncrementalDecoder) DecodedRows() int {
+	if d.decoder == nil || !d.parsed {
+		return 0
+	}
+	return int(C.avifDecoderDecodedRowCount(d.decoder))
+}
+
+// Bounds returns the bounds of the image as stored, or an empty rectangle if the header has not been parsed yet.
+func (d *IncrementalDecoder) Bounds() image.Rectangle {
+	if d.decoder == nil || !d.parsed {
+		return image.Rectangle{}
+	}
+	return image.Rect(0, 0, int(d.decoder.image.width), int(d.decoder.image.height))
+}
+
+// Done reports whether the whole image has been decoded.
+func (d *IncrementalDecoder) Done() bool {
+	return d.done
+}
+
+// Image converts the image decoded so far. Only the first DecodedRows rows hold image data; the rest are undefined
+// until Done reports true.
+//
+// Rows are counted in the image as stored, so the orientation and crop are not applied. Use Decode on the complete data
+// to get the upright image.
+func (d *IncrementalDecoder) Image() (image.Image, error) {
+	if d.DecodedRows() == 0 {
+		return nil, fmt.Errorf("no rows decoded yet")
+	}
+	return avifImageToImage(d.decoder.image)
+}
+
+// Close frees the native decoder and the data written to it. The decoder cannot be used afterward.
+func (d *IncrementalDecoder) Close() error {
+	if d.decoder != nil {
+		C.avifDecoderDestroy(d.decoder)
+		d.decoder = nil
+		d.io = nil
+	}
+	return nil
+}
@@ -0,0 +1,131 @@
+package avif
+
+/*
+#include <avif/avif.h>
+*/
+import "C"
+import (
+	"fmt"
+	"io"
+	"time"
+)
+
+// ChromaSubsampling is the YUV format of an image: how much colour resolution is kept relative to the luma plane.
+type ChromaSubsampling int
+
+const (
+	// ChromaSubsampling444 keeps full colour resolution.
+	ChromaSubsampling444 ChromaSubsampling = iota
+	// ChromaSubsampling422 halves the horizontal colour resolution.
+	ChromaSubsampling422
+	// ChromaSubsampling420 halves both the horizontal and vertical colour resolution.
+	ChromaSubsampling420
+	// ChromaSubsampling400 has no colour planes: the image is monochrome.
+	ChromaSubsampling400
+)
+
+// Info describes an AVIF image as stored in its container, read without decoding any pixel.
+//   - Width, Height: The dimensions of the image as displayed, after its orientation and crop.
+//   - Depth: The bit depth of the samples: 8, 10 or 12.
+//   - ChromaSubsampling: The YUV format of the image.
+//   - Color: The CICP colour description and YUV range of the image.
+//   - HasAlpha: Whether the image has an alpha channel.
+//   - Sequence: Whether the file holds an image sequence rather than a still image.
+//   - ImageCount: The number of frames of an image sequence; 1 for still images, including progressive ones, whose
+//     layers only DecodeLayers exposes.
+//   - Duration: The duration of one playback of an image sequence; 0 for still images.
+//   - LoopCount: How many times an image sequence loops, with the same semantics as gif.GIF; 0 for still images.
+//   - Progressive: Whether the image is made of progressive layers.
+//   - GridColumns, GridRows: The number of cells of an image split into a grid, such as images larger than what the
+//     encoder supports; 0 for images that are not grids.
+//   - Transform: The orientation and crop stored in the image.
+//   - ICCProfileSize, EXIFSize, XMPSize: The sizes in bytes of the metadata payloads; 0 when the image has none.
+type Info struct {
+	Width             int
+	Height            int
+	Depth             int
+	ChromaSubsampling ChromaSubsampling
+	Color             ColorInfo
+	HasAlpha          bool
+	Sequence          bool
+	ImageCount        int
+	Duration          time.Duration
+	LoopCount         int
+	Progressive       bool
+	GridColumns       int
+	GridRows          int
+	Transform         Transform
+	ICCProfileSize    int
+	EXIFSize          int
+	XMPSize           int
+}
+
+// Inspect reads the properties of an AVIF image from the provided io.Reader, without decoding it.
+//
+// Like DecodeConfig, readers implementing io.Seeker are read on demand, so only the header boxes are read; other
+// readers are read entirely first.
+//
+// It returns the properties of the image, or an error if its container cannot be parsed.
+func Inspect(reader io.Reader) (Info, error) {
+	input, err := newDecoderInput(reader, 0)
+	if err != nil {
+		return Info{}, fmt.Errorf("failed to inspect AVIF data: %w", err)
+	}
+	defer input.close()
+
+	decoder, err := parseInput(input, DecodeOptions{})
+	if err != nil {
+		return Info{}, fmt.Errorf("failed to inspect AVIF data: %w", err)
+	}
+	defer C.avifDecoderDestroy(decoder)
+
+	avifImg := decoder.image
+	width, height := displaySize(avifImg)
+
+	info := Info{
+		Width:             width,
+		Height:            height,
+		Depth:             int(avifImg.depth),
+		ChromaSubsampling: chromaSubsampling(avifImg.yuvFormat),
+		Color:             colorInfo(avifImg),
+		HasAlpha:          decoder.alphaPresent == C.AVIF_TRUE,
+		Sequence:          decoder.imageSequenceTrackPresent == C.AVIF_TRUE,
+		ImageCount:        int(decoder.imageCount),
+		Progressive:       decoder.progressiveState != C.AVIF_PROGRESSIVE_STATE_UNAVAILABLE,
+		Transform:         transformInfo(avifImg),
+		ICCProfileSize:    int(avifImg.icc.size),
+		EXIFSize:          int(avifImg.exif.size),
+		XMPSize:           int(avifImg.xmp.size),
+	}
+
+	if info.Sequence {
+		info.Duration = frameDelay(C.avifImageTiming{
+			timescale:            decoder.timescale,
+			durationInTimescales: decoder.durationInTimescales,
+		})
+		info.LoopCount = loopCount(decoder.repetitionCount)
+	} else {
+		// libavif does not expose the grid layout, so it is read from the container. Any failure only means the image
+		// is not a grid libavif could parse, which it would have rejected already.
+		columns, rows, err := readGridLayout(input.readerAt(), input.size)
+		if err == nil {
+			info.GridColumns, info.GridRows = columns, rows
+		}
+	}
+
+	return info, nil
+}
+
+// chromaSubsampling maps a libavif pixel format to the matching ChromaSubsampling value.
+func chromaSubsampling(format C.avifPixelFormat) ChromaSubsampling {
+	switch format {
+	case C.AVIF_PIXEL_FORMAT_YUV444:
+		return ChromaSubsampling444
+	case C.AVIF_PIXEL_FORMAT_YUV422:
+		return ChromaSubsampling422
+	case C.AVIF_PIXEL_FORMAT_YUV400:
+		return ChromaSubsampling400
+	default:
+		return ChromaSubsampling420
+	}
+}
@@ -0,0 +1,140 @@
+package avif
+
+/*
+#include <avif/avif.h>
+*/
+import "C"
+import (
+	"encoding/binary"
+	"image"
+	"unsafe"
+)
+
+// avifImageToNative converts a decoded avifImage to the Go image type closest to how it is stored, as described for
+// OutputNative. With upright set, image.YCbCr is only used when the image needs no rotation or mirroring, since
+// applyTransform can only crop subsampled planes.
+func avifImageToNative(avifImg *C.avifImage, upright bool) (image.Image, error) {
+	hasAlpha := avifImg.alphaPlane != nil
+	monochrome := avifImg.yuvFormat == C.AVIF_PIXEL_FORMAT_YUV400
+
+	if avifImg.depth > 8 {
+		switch {
+		case monochrome && !hasAlpha:
+			return avifImageToGray16(avifImg)
+		case hasAlpha:
+			return avifImageToNRGBA64(avifImg)
+		default:
+			return avifImageToRGBA64(avifImg)
+		}
+	}
+
+	subsampling, ok := ycbcrSubsampling(avifImg)
+	if ok && !hasAlpha && (!upright || transformInfo(avifImg).Orientation <= 1) {
+		return avifImageToYCbCr(avifImg, subsampling), nil
+	}
+
+	return avifImageToImage(avifImg)
+}
+
+// ycbcrSubsampling returns the image.YCbCr subsample ratio matching an 8-bit avifImage, and whether its samples can be
+// copied into an image.YCbCr as is. That requires the full range BT.601 matrix Go uses to convert YCbCr to RGB, which
+// libavif also assumes when the matrix is unspecified.
+func ycbcrSubsampling(avifImg *C.avifImage) (image.YCbCrSubsampleRatio, bool) {
+	if avifImg.depth != 8 || avifImg.yuvRange != C.AVIF_RANGE_FULL {
+		return 0, false
+	}
+
+	switch avifImg.matrixCoefficients {
+	case C.AVIF_MATRIX_COEFFICIENTS_BT470BG, C.AVIF_MATRIX_COEFFICIENTS_BT601, C.AVIF_MATRIX_COEFFICIENTS_UNSPECIFIED:
+	default:
+		return 0, false
+	}
+
+	switch avifImg.yuvFormat {
+	case C.AVIF_PIXEL_FORMAT_YUV444:
+		return image.YCbCrSubsampleRatio444, true
+	case C.AVIF_PIXEL_FORMAT_YUV422:
+		return image.YCbCrSubsampleRatio422, true
+	case C.AVIF_PIXEL_FORMAT_YUV420:
+		return image.YCbCrSubsampleRatio420, true
+	default:
+		return 0, false
+	}
+}
+
+// avifImageToYCbCr copies the planes of a decoded 8-bit avifImage into a YCbCr image, without any colour conversion.
+func avifImageToYCbCr(avifImg *C.avifImage, subsampling image.YCbCrSubsampleRatio) *image.YCbCr {
+	img := image.NewYCbCr(image.Rect(0, 0, int(avifImg.width), int(avifImg.height)), subsampling)
+
+	// The chroma planes are allocated without padding, so their stride is their width
+	copyPlane(img.Y, img.YStride, avifImg, 0, img.Rect.Dx(), img.Rect.Dy())
+	chromaWidth, chromaHeight := img.CStride, len(img.Cb)/img.CStride
+	copyPlane(img.Cb, img.CStride, avifImg, 1, chromaWidth, chromaHeight)
+	copyPlane(img.Cr, img.CStride, avifImg, 2, chromaWidth, chromaHeight)
+
+	return img
+}
+
+// copyPlane copies width x height 8-bit samples of one YUV plane of avifImg into dst.
+func copyPlane(dst []byte, stride int, avifImg *C.avifImage, plane, width, height int) {
+	rowBytes := int(avifImg.yuvRowBytes[plane])
+	for y := 0; y < height; y++ {
+		srcPtr := unsafe.Add(unsafe.Pointer(avifImg.yuvPlanes[plane]), y*rowBytes)
+		copy(dst[y*stride:y*stride+width], unsafe.Slice((*byte)(srcPtr), width))
+	}
+}
+
+// avifImageToRGBA64 converts a decoded avifImage to an RGBA64 image, premultiplying the colour by the alpha channel as
+// image.RGBA64 requires.
+func avifImageToRGBA64(avifImg *C.avifImage) (*image.RGBA64, error) {
+	img := image.NewRGBA64(image.Rect(0, 0, int(avifImg.width), int(avifImg.height)))
+	if err := convertToRGBA(avifImg, img.Pix, img.Stride, 16, true); err != nil {
+		return nil, err
+	}
+	return img, nil
+}
+
+// avifImageToNRGBA64 converts a decoded avifImage to an NRGBA64 image, keeping the straight alpha stored in the file.
+func avifImageToNRGBA64(avifImg *C.avifImage) (*image.NRGBA64, error) {
+	img := image.NewNRGBA64(image.Rect(0, 0, int(avifImg.width), int(avifImg.height)))
+	if err := convertToRGBA(avifImg, img.Pix, img.Stride, 16, false); err != nil {
+		return nil, err
+	}
+	return img, nil
+}
+
+// avifImageToGray16 converts a decoded high bit depth monochrome avifImage to a Gray16 image.
+//
+// Full range luma is scaled to 16 bits directly; limited range goes through libavif's YUV to RGB conversion so the
+// values match what avifImageToRGBA64 would return.
+func avifImageToGray16(avifImg *C.avifImage) (*image.Gray16, error) {
+	width := int(avifImg.width)
+	height := int(avifImg.height)
+	img := image.NewGray16(image.Rect(0, 0, width, height))
+
+	if avifImg.yuvRange == C.AVIF_RANGE_FULL {
+		depth := int(avifImg.depth)
+		rowBytes := int(avifImg.yuvRowBytes[0])
+		for y := 0; y < height; y++ {
+			srcPtr := unsafe.Add(unsafe.Pointer(avifImg.yuvPlanes[0]), y*rowBytes)
+			for x, v := range unsafe.Slice((*uint16)(srcPtr), width) {
+				// Replicate the high bits so the maximum value at the source depth maps to 0xffff
+				binary.BigEndian.PutUint16(img.Pix[y*img.Stride+x*2:], v<<(16-depth)|v>>(2*depth-16))
+			}
+		}
+		return img, nil
+	}
+
+	rgba, err := avifImageToRGBA64(avifImg)
+	if err != nil {
+		return nil, err
+	}
+
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			copy(img.Pix[y*img.Stride+x*2:y*img.Stride+x*2+2], rgba.Pix[y*rgba.Stride+x*8:])
+		}
+	}
+
+	return img, nil
+}
@@ -0,0 +1,226 @@
+package avif
+
+/*
+#include <avif/avif.h>
+*/
+import "C"
+import (
+	"fmt"
+	"image"
+	"io"
+	"time"
+)
+
+// SequenceDecoder gives random access to the frames of an AVIF image sequence.
+//
+// It keeps a single native decoder open for all calls, so seeking only decodes the frames between the nearest keyframe
+// and the requested one. Close must be called to free the decoder. A SequenceDecoder is not safe for concurrent use.
+type SequenceDecoder struct {
+	decoder *C.avifDecoder
+	input   *decoderInput
+	options DecodeOptions
+	// allKeyframes is set for sequences without a sync sample table, where every frame is a keyframe. libavif only
+	// treats the first frame as one in that case.
+	allKeyframes bool
+}
+
+// NewSequenceDecoder reads AVIF image data from the provided io.Reader and parses its container, without decoding any
+// frame yet. Still images are treated as a sequence with a single frame.
+//
+// It returns the decoder or an error if the data cannot be read or parsed.
+func NewSequenceDecoder(reader io.Reader) (*SequenceDecoder, error) {
+	return NewSequenceDecoderWithOptions(reader, nil)
+}
+
+// NewSequenceDecoderWithOptions reads AVIF image data like NewSequenceDecoder, using the given options. If options is
+// nil, default values are used.
+//
+// The limits are checked while the container is parsed, before any frame is decoded, and the reader is not read past
+// MaxInputSize. Frames are returned as Output and IgnoreTransforms select, like DecodeWithOptions does.
+//
+// It returns the decoder or an error if the data cannot be read or parsed.
+func NewSequenceDecoderWithOptions(reader io.Reader, options *DecodeOptions) (*SequenceDecoder, error) {
+	return readSequence(reader, options, false)
+}
+
+// readSequence reads the AVIF data from reader and parses it into a SequenceDecoder using the given options. With
+// progressive set, the layers of a progressive image are exposed as frames instead of a single full-quality frame.
+func readSequence(reader io.Reader, options *DecodeOptions, progressive bool) (*SequenceDecoder, error) {
+	if options == nil {
+		options = &DecodeOptions{}
+	}
+	if err := checkDecodeOptions(*options); err != nil {
+		return nil, err
+	}
+
+	data, err := readInput(reader, options.MaxInputSize)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode AVIF data: %w", err)
+	}
+	if len(data) == 0 {
+		return nil, fmt.Errorf("cannot decode empty data: %w", ErrInvalidData)
+	}
+
+	decoder := newDecoder(*options)
+	if decoder == nil {
+		return nil, fmt.Errorf("failed to create AVIF decoder")
+	}
+	if progressive {
+		decoder.allowProgressive = C.AVIF_TRUE
+	}
+
+	// The decoder reads straight from the data, so the input stays open as long as the decoder does
+	sequence := &SequenceDecoder{decoder: decoder, input: memoryInput(data), options: *options}
+	if err = sequence.parse(); err != nil {
+		sequence.Close()
+		return nil, err
+	}
+
+	if hasTable, err := hasSyncSampleTable(sequence.input.readerAt(), sequence.input.size); err == nil {
+		sequence.allKeyframes = !hasTable
+	}
+
+	return sequence, nil
+}
+
+// parse points the decoder at the input and parses the container, checking it against the limits in the options.
+func (d *SequenceDecoder) parse() error {
+	if err := d.input.attach(d.decoder); err != nil {
+		return err
+	}
+	if result := C.avifDecoderParse(d.decoder); result != C.AVIF_RESULT_OK {
+		return fmt.Errorf("failed to parse AVIF image: %w", parseError(result, d.decoder))
+	}
+	return enforceLimits(d.decoder, d.options)
+}
+
+// FrameCount returns the number of frames in the sequence.
+func (d *SequenceDecoder) FrameCount() int {
+	if d.decoder == nil {
+		return 0
+	}
+	return int(d.decoder.imageCount)
+}
+
+// LoopCount returns how many times the sequence loops, with the same semantics as gif.GIF: 0 loops forever, -1 shows
+// each frame only once, and n > 0 shows each frame n+1 times.
+func (d *SequenceDecoder) LoopCount() int {
+	if d.decoder == nil {
+		return 0
+	}
+	return loopCount(d.decoder.repetitionCount)
+}
+
+// Frame decodes the frame at the given index, converted and oriented as the decoder's options select.
+//
+// It returns the decoded frame or an error if the index is out of range or decoding fails.
+func (d *SequenceDecoder) Frame(index int) (image.Image, error) {
+	if err := d.checkIndex(index); err != nil {
+		return nil, err
+	}
+
+	result := C.avifDecoderNthImage(d.decoder, C.uint32_t(index))
+	if result != C.AVIF_RESULT_OK {
+		return nil, fmt.Errorf("failed to decode frame %d: %w", index, decoderError(OpDecode, result, d.decoder))
+	}
+
+	return avifImageToOutput(d.decoder.image, d.options)
+}
+
+// Delay returns how long the frame at the given index is shown.
+func (d *SequenceDecoder) Delay(index int) (time.Duration, error) {
+	if err := d.checkIndex(index); err != nil {
+		return 0, err
+	}
+
+	var timing C.avifImageTiming
+	result := C.avifDecoderNthImageTiming(d.decoder, C.uint32_t(index), &timing)
+	if result != C.AVIF_RESULT_OK {
+		return 0, fmt.Errorf("failed to get timing of frame %d: %w", index, decoderError(OpParse, result, d.decoder))
+	}
+
+	return frameDelay(timing), nil
+}
+
+// IsKeyframe reports whether the frame at the given index can be decoded without decoding any frame before it. It
+// returns false for indexes out of range.
+func (d *SequenceDecoder) IsKeyframe(index int) bool {
+	if d.checkIndex(index) != nil {
+		return false
+	}
+	if d.allKeyframes {
+		return true
+	}
+	return C.avifDecoderIsKeyframe(d.decoder, C.uint32_t(index)) == C.AVIF_TRUE
+}
+
+// NearestKeyframe returns the index of the frame libavif starts decoding from when seeking to the given index: the
+// closest keyframe at or before it. Sequences without a sync sample table are always decoded from the first frame, even
+// though IsKeyframe reports every frame of them as a keyframe. It returns 0 for indexes out of range.
+func (d *SequenceDecoder) NearestKeyframe(index int) int {
+	if d.checkIndex(index) != nil {
+		return 0
+	}
+	return int(C.avifDecoderNearestKeyframe(d.decoder, C.uint32_t(index)))
+}
+
+// Close frees the native decoder and the data it reads from. The decoder cannot be used afterward.
+func (d *SequenceDecoder) Close() error {
+	if d.decoder != nil {
+		C.avifDecoderDestroy(d.decoder)
+		d.input.close()
+		d.decoder = nil
+		d.input = nil
+	}
+	return nil
+}
+
+// checkIndex returns an error if the decoder is closed or the index is not a valid frame index.
+func (d *SequenceDecoder) checkIndex(index int) error {
+	if d.decoder == nil {
+		return fmt.Errorf("sequence decoder is closed")
+	}
+	if index < 0 || index >= int(d.decoder.imageCount) {
+		return fmt.Errorf("frame index %d out of range [0, %d)", index, int(d.decoder.imageCount))
+	}
+	return nil
+}
+
+// DecodeLayers reads a progressive AVIF image from the provided io.Reader and decodes each of its layers, from the
+// low-quality preview to the full-quality image. Images that are not progressive return a single layer.
+//
+// libavif scales layers coded at a reduced size up to the image size, so every layer has the bounds of the full image.
+// SVT-AV1 cannot encode layered images, so Encode has no option to write them; they come from other encoders, such as
+// libaom through avifenc --progressive.
+//
+// It returns the decoded layers or an error if the decoding process fails.
+func DecodeLayers(reader io.Reader) ([]image.Image, error) {
+	return DecodeLayersWithOptions(reader, nil)
+}
+
+// DecodeLayersWithOptions decodes the layers of a progressive AVIF image like DecodeLayers, using the given options.
+// If options is nil, default values are used. MaxImageCount limits the number of layers.
+//
+// It returns the decoded layers or an error if the decoding process fails.
+func DecodeLayersWithOptions(reader io.Reader, options *DecodeOptions) ([]image.Image, error) {
+	sequence, err := readSequence(reader, options, true)
+	if err != nil {
+		return nil, err
+	}
+	defer sequence.Close()
+
+	// Image sequences are not progressive; only their first frame is returned
+	count := sequence.FrameCount()
+	if sequence.decoder.progressiveState != C.AVIF_PROGRESSIVE_STATE_ACTIVE {
+		count = 1
+	}
+
+	layers := make([]image.Image, count)
+	for i := range layers {
+		if layers[i], err = sequence.Frame(i); err != nil {
+			return nil, err
+		}
+	}
+
+	return layers, nil
+}
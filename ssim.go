@@ -0,0 +1,92 @@
+package avif
+
+import (
+	"image"
+)
+
+// SSIM constants for 8-bit samples, from Wang et al., "Image Quality Assessment: From Error Visibility to Structural
+// Similarity" (2004).
+const (
+	ssimWindow = 8
+	ssimStep   = 4
+	ssimC1     = (0.01 * 255) * (0.01 * 255)
+	ssimC2     = (0.03 * 255) * (0.03 * 255)
+)
+
+// ssim returns the structural similarity of two RGBA images of the same size, from 1 for identical images down to 0
+// (or slightly below) for unrelated ones.
+//
+// It compares the luma of the images, where compression artefacts are most visible, over 8x8 windows spaced 4 pixels
+// apart and averages the result. Windows are shrunk to fit images smaller than that, and an extra column and row of
+// windows is aligned with the right and bottom edges when the spacing does not reach them.
+func ssim(a, b *image.RGBA) float64 {
+	width := a.Rect.Dx()
+	height := a.Rect.Dy()
+	lumaA := luma(a)
+	lumaB := luma(b)
+
+	windowWidth := min(ssimWindow, width)
+	windowHeight := min(ssimWindow, height)
+	count := float64(windowWidth * windowHeight)
+
+	var total float64
+	windows := 0
+	for _, y0 := range windowOffsets(height, windowHeight) {
+		for _, x0 := range windowOffsets(width, windowWidth) {
+			var sumA, sumB, sumAA, sumBB, sumAB float64
+			for y := y0; y < y0+windowHeight; y++ {
+				for x := x0; x < x0+windowWidth; x++ {
+					va := lumaA[y*width+x]
+					vb := lumaB[y*width+x]
+					sumA += va
+					sumB += vb
+					sumAA += va * va
+					sumBB += vb * vb
+					sumAB += va * vb
+				}
+			}
+
+			meanA := sumA / count
+			meanB := sumB / count
+			varA := sumAA/count - meanA*meanA
+			varB := sumBB/count - meanB*meanB
+			covariance := sumAB/count - meanA*meanB
+
+			total += (2*meanA*meanB + ssimC1) * (2*covariance + ssimC2) /
+				((meanA*meanA + meanB*meanB + ssimC1) * (varA + varB + ssimC2))
+			windows++
+		}
+	}
+
+	return total / float64(windows)
+}
+
+// windowOffsets returns where the windows of the given size start along a dimension of the given length: every ssimStep
+// pixels, plus one last window ending at the edge if the others stop short of it.
+func windowOffsets(length, window int) []int {
+	var offsets []int
+	for offset := 0; offset+window <= length; offset += ssimStep {
+		offsets = append(offsets, offset)
+	}
+	if last := length - window; offsets[len(offsets)-1] != last {
+		offsets = append(offsets, last)
+	}
+	return offsets
+}
+
+// luma returns the BT.601 luma of every pixel of img, row by row.
+func luma(img *image.RGBA) []float64 {
+	width := img.Rect.Dx()
+	height := img.Rect.Dy()
+	values := make([]float64, width*height)
+
+	for y := 0; y < height; y++ {
+		row := img.Pix[img.PixOffset(img.Rect.Min.X, img.Rect.Min.Y+y):]
+		for x := 0; x < width; x++ {
+			p := row[x*4 : x*4+3]
+			values[y*width+x] = 0.299*float64(p[0]) + 0.587*float64(p[1]) + 0.114*float64(p[2])
+		}
+	}
+
+	return values
+}
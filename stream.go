@@ -0,0 +1,255 @@
+package avif
+
+/*
+#include <stdlib.h>
+#include <avif/avif.h>
+
+extern int readerIORead(uintptr_t handle, uint64_t offset, uint8_t * buffer, size_t size, size_t * read);
+
+// An avifIO over a Go io.ReaderAt, identified by a cgo.Handle. Every read gets its own buffer that lives as long as the
+// reader does, so libavif can use the bytes in place instead of copying them once more.
+typedef struct reader_io {
+    avifIO io; // Must be the first member, libavif only sees this part
+    uintptr_t handle;
+    uint8_t ** chunks;
+    size_t count;
+    size_t capacity;
+} reader_io;
+
+static avifResult reader_io_read(struct avifIO * io, uint32_t readFlags, uint64_t offset, size_t size, avifROData * out) {
+    static const uint8_t empty = 0;
+    reader_io * self = (reader_io *)io;
+    if (readFlags != 0) {
+        return AVIF_RESULT_IO_ERROR;
+    }
+
+    if (offset > self->io.sizeHint) {
+        return AVIF_RESULT_IO_ERROR;
+    }
+    if (size > self->io.sizeHint - offset) {
+        size = (size_t)(self->io.sizeHint - offset);
+    }
+    if (size == 0) {
+        out->data = &empty;
+        out->size = 0;
+        return AVIF_RESULT_OK;
+    }
+
+    if (self->count == self->capacity) {
+        size_t capacity = self->capacity ? self->capacity * 2 : 16;
+        uint8_t ** grown = (uint8_t **)realloc(self->chunks, capacity * sizeof(uint8_t *));
+        if (grown == NULL) {
+            return AVIF_RESULT_OUT_OF_MEMORY;
+        }
+        self->chunks = grown;
+        self->capacity = capacity;
+    }
+
+    uint8_t * chunk = (uint8_t *)malloc(size);
+    if (chunk == NULL) {
+        return AVIF_RESULT_OUT_OF_MEMORY;
+    }
+    self->chunks[self->count++] = chunk;
+
+    size_t read = 0;
+    if (readerIORead(self->handle, offset, chunk, size, &read) != 0) {
+        return AVIF_RESULT_IO_ERROR;
+    }
+
+    out->data = chunk;
+    out->size = read;
+    return AVIF_RESULT_OK;
+}
+
+static void reader_io_destroy(struct avifIO * io) {
+    reader_io * self = (reader_io *)io;
+    for (size_t i = 0; i < self->count; i++) {
+        free(self->chunks[i]);
+    }
+    free(self->chunks);
+    free(self);
+}
+
+static avifIO * reader_io_create(uintptr_t handle, uint64_t size) {
+    reader_io * self = (reader_io *)calloc(1, sizeof(reader_io));
+    if (self == NULL) {
+        return NULL;
+    }
+
+    self->io.destroy = reader_io_destroy;
+    self->io.read = reader_io_read;
+    self->io.sizeHint = size;
+    self->io.persistent = AVIF_TRUE;
+    self->handle = handle;
+    return &self->io;
+}
+*/
+import "C"
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"runtime"
+	"runtime/cgo"
+	"unsafe"
+)
+
+// decoderInput is the source of the AVIF data handed to a decoder.
+//
+// Readers that can seek are read on demand through a custom avifIO, so parsing the header only reads the header boxes
+// and the payload is only ever held once, in the buffers libavif decodes from. Other readers are read into memory,
+// which libavif then reads in place instead of from a C copy.
+type decoderInput struct {
+	size   int64
+	data   []byte
+	pinner runtime.Pinner
+	source *readerSource
+	handle cgo.Handle
+}
+
+// readerSource serves the reads libavif makes from an io.ReaderAt, and keeps the first error so it can be reported
+// instead of libavif's generic I/O error.
+type readerSource struct {
+	reader io.ReaderAt
+	err    error
+}
+
+// newDecoderInput prepares the data of reader for decoding. Seekable readers are read from their current position to
+// their end; readers whose Seek fails, such as an *os.File of a pipe, are read into memory like other readers. Inputs
+// larger than maxSize fail with ErrInputTooLarge, unless maxSize is 0. The input must be closed once the decoder using
+// it is destroyed.
+func newDecoderInput(reader io.Reader, maxSize int64) (*decoderInput, error) {
+	if seeker, ok := reader.(io.ReadSeeker); ok {
+		if start, end, err := seekRange(seeker); err == nil {
+			if maxSize > 0 && end-start > maxSize {
+				return nil, fmt.Errorf("%w: %d bytes, maximum %d", ErrInputTooLarge, end-start, maxSize)
+			}
+
+			readerAt, ok := reader.(io.ReaderAt)
+			if !ok {
+				readerAt = &seekerReaderAt{seeker: seeker}
+			}
+
+			source := &readerSource{reader: io.NewSectionReader(readerAt, start, end-start)}
+			return &decoderInput{size: end - start, source: source, handle: cgo.NewHandle(source)}, nil
+		}
+	}
+
+	data, err := readInput(reader, maxSize)
+	if err != nil {
+		return nil, err
+	}
+	return memoryInput(data), nil
+}
+
+// readInput reads reader to its end. Inputs larger than maxSize fail with ErrInputTooLarge without being read past the
+// limit, unless maxSize is 0.
+func readInput(reader io.Reader, maxSize int64) ([]byte, error) {
+	if maxSize > 0 {
+		// Read one byte more than allowed to tell inputs of exactly maxSize from larger ones
+		reader = io.LimitReader(reader, maxSize+1)
+	}
+	data, err := io.ReadAll(reader)
+	if err != nil {
+		return nil, err
+	}
+	if maxSize > 0 && int64(len(data)) > maxSize {
+		return nil, fmt.Errorf("%w: more than %d bytes", ErrInputTooLarge, maxSize)
+	}
+	return data, nil
+}
+
+// seekRange returns the current position of seeker and the position of its end. A failed Seek leaves the position
+// unchanged, so the reader can still be read from the start.
+func seekRange(seeker io.Seeker) (int64, int64, error) {
+	start, err := seeker.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return 0, 0, err
+	}
+	end, err := seeker.Seek(0, io.SeekEnd)
+	if err != nil {
+		return 0, 0, err
+	}
+	return start, end, nil
+}
+
+// memoryInput wraps AVIF data that is already in memory.
+func memoryInput(data []byte) *decoderInput {
+	return &decoderInput{size: int64(len(data)), data: data}
+}
+
+// attach points the decoder at the input.
+func (in *decoderInput) attach(decoder *C.avifDecoder) error {
+	if in.source == nil {
+		// The decoder keeps a pointer to the data, so it must not move until the input is closed
+		in.pinner.Pin(&in.data[0])
+		result := C.avifDecoderSetIOMemory(decoder, (*C.uint8_t)(unsafe.Pointer(&in.data[0])), C.size_t(len(in.data)))
+		if result != C.AVIF_RESULT_OK {
+			return fmt.Errorf("failed to set AVIF data: %w", decoderError(OpParse, result, decoder))
+		}
+		return nil
+	}
+
+	avifIO := C.reader_io_create(C.uintptr_t(in.handle), C.uint64_t(in.size))
+	if avifIO == nil {
+		return fmt.Errorf("failed to create AVIF reader")
+	}
+
+	// The decoder takes ownership of the reader and destroys it with itself
+	C.avifDecoderSetIO(decoder, avifIO)
+	return nil
+}
+
+// err returns the error the reader failed with, if any.
+func (in *decoderInput) err() error {
+	if in.source == nil {
+		return nil
+	}
+	return in.source.err
+}
+
+// readerAt returns a reader over the input data, for reading boxes libavif does not expose.
+func (in *decoderInput) readerAt() io.ReaderAt {
+	if in.source == nil {
+		return bytes.NewReader(in.data)
+	}
+	return in.source.reader
+}
+
+// close releases the input. It must only be called once the decoder using it is destroyed.
+func (in *decoderInput) close() {
+	if in.source != nil {
+		in.handle.Delete()
+		in.source = nil
+	}
+	in.pinner.Unpin()
+}
+
+// read fills buffer with the bytes at offset, which may only be short at the end of the data.
+func (s *readerSource) read(offset int64, buffer []byte) (int, error) {
+	n, err := s.reader.ReadAt(buffer, offset)
+	if n == len(buffer) || errors.Is(err, io.EOF) {
+		return n, nil
+	}
+	if err == nil {
+		err = io.ErrUnexpectedEOF
+	}
+	if s.err == nil {
+		s.err = err
+	}
+	return n, err
+}
+
+// seekerReaderAt reads at arbitrary offsets of an io.ReadSeeker that does not implement io.ReaderAt itself.
+type seekerReaderAt struct {
+	seeker io.ReadSeeker
+}
+
+// ReadAt implements io.ReaderAt by seeking before every read.
+func (r *seekerReaderAt) ReadAt(p []byte, offset int64) (int, error) {
+	if _, err := r.seeker.Seek(offset, io.SeekStart); err != nil {
+		return 0, err
+	}
+	return io.ReadFull(r.seeker, p)
+}
@@ -0,0 +1,25 @@
+package avif
+
+/*
+#include <stdint.h>
+#include <stddef.h>
+*/
+import "C"
+import (
+	"runtime/cgo"
+	"unsafe"
+)
+
+// readerIORead is called by the reader_io avifIO to read size bytes at offset into buffer. It stores the number of
+// bytes read, which is only short at the end of the data, and returns 0 on success.
+//
+//export readerIORead
+func readerIORead(handle C.uintptr_t, offset C.uint64_t, buffer *C.uint8_t, size C.size_t, read *C.size_t) C.int {
+	source := cgo.Handle(handle).Value().(*readerSource)
+	n, err := source.read(int64(offset), unsafe.Slice((*byte)(unsafe.Pointer(buffer)), int(size)))
+	*read = C.size_t(n)
+	if err != nil {
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,215 @@
+package avif
+
+import (
+	"context"
+	"fmt"
+	"image"
+	"image/draw"
+	"io"
+)
+
+// EncodeToSize encodes an image into the AVIF format at the highest quality whose output fits in maxSize bytes, and
+// writes it to the provided writer.
+//
+// The quality is found with a binary search from 0 to 100, encoding the image about 7 times. Each attempt sets both
+// ColorQuality and AlphaQuality to the quality being tried, so the values in options are ignored; the other options
+// apply as in Encode. The RGB to YUV conversion is done once and shared by every attempt.
+//
+// Parameters:
+//   - writer: The destination where the encoded AVIF image will be written.
+//   - img: The input image to be encoded.
+//   - maxSize: The maximum size of the encoded image in bytes.
+//   - options: A pointer to an Options struct that specifies encoding parameters. If nil, default values are used.
+//
+// Returns:
+//   - The quality the image was encoded with.
+//   - An error wrapping ErrTargetUnreachable if the image does not fit even at quality 0, an error if encoding or
+//     writing fails, otherwise nil.
+func EncodeToSize(writer io.Writer, img image.Image, maxSize int, options *Options) (int, error) {
+	return EncodeToSizeContext(context.Background(), writer, img, maxSize, options)
+}
+
+// EncodeToSizeContext encodes an image like EncodeToSize, stopping when ctx is done. The context is checked before
+// every attempt, and like EncodeContext before each grid cell is converted. Nothing is written if ctx is done first.
+//
+// Returns:
+//   - The quality the image was encoded with.
+//   - ctx.Err() if ctx is done before encoding completes, otherwise the same errors as EncodeToSize.
+func EncodeToSizeContext(ctx context.Context, writer io.Writer, img image.Image, maxSize int,
+	options *Options) (int, error) {
+	if maxSize <= 0 {
+		return 0, fmt.Errorf("target size must be positive")
+	}
+
+	search, err := newQualitySearch(ctx, img, options)
+	if err != nil {
+		return 0, err
+	}
+	defer search.destroy()
+
+	// The size grows with the quality, so the answer is just below the lowest quality that no longer fits
+	quality, err := search.lowest(func(attempt *qualityAttempt) (bool, error) {
+		return len(attempt.data) > maxSize, nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	if quality == 0 {
+		return 0, fmt.Errorf("%w: %d bytes at quality 0, maximum %d", ErrTargetUnreachable,
+			len(search.attempts[0].data), maxSize)
+	}
+
+	best := search.attempts[quality-1]
+	if _, err = writer.Write(best.data); err != nil {
+		return 0, fmt.Errorf("failed to write AVIF image: %v", err)
+	}
+
+	return best.quality, nil
+}
+
+// EncodeToSSIM encodes an image into the AVIF format at the lowest quality whose output still looks as good as
+// minScore, and writes it to the provided writer.
+//
+// Every attempt is decoded again and compared with img using SSIM (structural similarity) on the luma channel, which
+// is 1 for identical images and decreases as compression artefacts become visible; 0.95 is a good target for photos.
+// The quality is found with a binary search from 0 to 100 like EncodeToSize, so the same options apply.
+//
+// Parameters:
+//   - writer: The destination where the encoded AVIF image will be written.
+//   - img: The input image to be encoded.
+//   - minScore: The minimum SSIM of the encoded image, above 0 and up to 1.
+//   - options: A pointer to an Options struct that specifies encoding parameters. If nil, default values are used.
+//
+// Returns:
+//   - The quality the image was encoded with.
+//   - The SSIM of the encoded image, at least minScore.
+//   - An error wrapping ErrTargetUnreachable if the image scores less than minScore even at quality 100, an error if
+//     encoding, decoding or writing fails, otherwise nil.
+func EncodeToSSIM(writer io.Writer, img image.Image, minScore float64, options *Options) (int, float64, error) {
+	return EncodeToSSIMContext(context.Background(), writer, img, minScore, options)
+}
+
+// EncodeToSSIMContext encodes an image like EncodeToSSIM, stopping when ctx is done. The context is checked before
+// every attempt and before each attempt is decoded, and like EncodeContext before each grid cell is converted. Nothing
+// is written if ctx is done first.
+//
+// Returns:
+//   - The quality the image was encoded with and its SSIM.
+//   - ctx.Err() if ctx is done before encoding completes, otherwise the same errors as EncodeToSSIM.
+func EncodeToSSIMContext(ctx context.Context, writer io.Writer, img image.Image, minScore float64,
+	options *Options) (int, float64, error) {
+	if minScore <= 0 || minScore > 1 {
+		return 0, 0, fmt.Errorf("minimum score must be above 0 and up to 1")
+	}
+
+	search, err := newQualitySearch(ctx, img, options)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer search.destroy()
+
+	bounds := img.Bounds()
+	source := image.NewRGBA(image.Rect(0, 0, bounds.Dx(), bounds.Dy()))
+	draw.Draw(source, source.Bounds(), img, bounds.Min, draw.Src)
+
+	// The score grows with the quality, so the answer is the lowest quality that still scores well enough
+	quality, err := search.lowest(func(attempt *qualityAttempt) (bool, error) {
+		decoded, err := decodeAVIFToRGBA(ctx, attempt.data)
+		if err != nil {
+			return false, err
+		}
+		// Grids may be stored with a few pixels of padding on the right and bottom
+		attempt.score = ssim(source, decoded.SubImage(source.Rect).(*image.RGBA))
+		return attempt.score >= minScore, nil
+	})
+	if err != nil {
+		return 0, 0, err
+	}
+	if quality > 100 {
+		return 0, 0, fmt.Errorf("%w: SSIM %.4f at quality 100, minimum %.4f", ErrTargetUnreachable,
+			search.attempts[100].score, minScore)
+	}
+
+	best := search.attempts[quality]
+	if _, err = writer.Write(best.data); err != nil {
+		return 0, 0, fmt.Errorf("failed to write AVIF image: %v", err)
+	}
+
+	return best.quality, best.score, nil
+}
+
+// qualitySearch encodes an image at several qualities, converting its pixels to YUV only once.
+type qualitySearch struct {
+	ctx      context.Context
+	grid     *imageGrid
+	options  Options
+	attempts map[int]*qualityAttempt
+}
+
+// qualityAttempt is the image encoded at one quality, with the score given to it by the search, if any.
+type qualityAttempt struct {
+	quality int
+	data    []byte
+	score   float64
+}
+
+// newQualitySearch validates the options and converts img to YUV. The search must be destroyed by the caller.
+func newQualitySearch(ctx context.Context, img image.Image, options *Options) (*qualitySearch, error) {
+	opts, err := prepareOptions(img, options)
+	if err != nil {
+		return nil, err
+	}
+
+	grid, err := newImageGrid(ctx, newPixels(img, opts), opts)
+	if err != nil {
+		return nil, err
+	}
+
+	return &qualitySearch{ctx: ctx, grid: grid, options: opts, attempts: make(map[int]*qualityAttempt)}, nil
+}
+
+// lowest returns the lowest quality from 0 to 100 whose attempt is accepted, or 101 if none is. Acceptance must not
+// change back as the quality grows, which lets a binary search find it in about 7 attempts. Every attempt is kept, so
+// both the returned quality and the one below it can be looked up once the search is done.
+func (s *qualitySearch) lowest(accept func(*qualityAttempt) (bool, error)) (int, error) {
+	low, high := 0, 101
+	for low < high {
+		quality := (low + high) / 2
+		attempt, err := s.encode(quality)
+		if err != nil {
+			return 0, err
+		}
+
+		accepted, err := accept(attempt)
+		if err != nil {
+			return 0, err
+		}
+		if accepted {
+			high = quality
+		} else {
+			low = quality + 1
+		}
+	}
+	return low, nil
+}
+
+// encode encodes the image with both ColorQuality and AlphaQuality set to quality.
+func (s *qualitySearch) encode(quality int) (*qualityAttempt, error) {
+	options := s.options
+	options.ColorQuality = quality
+	options.AlphaQuality = quality
+
+	data, err := s.grid.encode(s.ctx, options)
+	if err != nil {
+		return nil, err
+	}
+
+	attempt := &qualityAttempt{quality: quality, data: data}
+	s.attempts[quality] = attempt
+	return attempt, nil
+}
+
+// destroy frees the converted image.
+func (s *qualitySearch) destroy() {
+	s.grid.destroy()
+}
@@ -0,0 +1,369 @@
+package tests
+
+import (
+	"bytes"
+	"context"
+	"image"
+	"testing"
+	"time"
+
+	"github.com/DND-IT/avif-go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEncodeAnimation(t *testing.T) {
+	frames := newTestFrames(4, 32, 24)
+	delays := []time.Duration{100 * time.Millisecond, 200 * time.Millisecond, 50 * time.Millisecond, time.Second}
+
+	t.Run("default options", func(t *testing.T) {
+		buf := &bytes.Buffer{}
+		err := avif.EncodeAnimation(buf, frames, delays, nil)
+
+		require.NoError(t, err)
+		assert.Equal(t, "ftypavis", string(buf.Bytes()[4:12]))
+
+		img, format, err := image.Decode(buf)
+		require.NoError(t, err)
+		assert.Equal(t, "avif", format)
+		assert.Equal(t, frames[0].Bounds().Size(), img.Bounds().Size())
+	})
+
+	t.Run("custom options", func(t *testing.T) {
+		buf := &bytes.Buffer{}
+		options := &avif.AnimationOptions{
+			Options:          avif.Options{Speed: 8, ColorQuality: 70, AlphaQuality: 70},
+			Timescale:        90000,
+			KeyframeInterval: 2,
+			LoopCount:        3,
+		}
+
+		err := avif.EncodeAnimation(buf, frames, delays, options)
+
+		assert.NoError(t, err)
+		assert.NotEmpty(t, buf.Bytes())
+	})
+
+	t.Run("streaming encoder", func(t *testing.T) {
+		buf := &bytes.Buffer{}
+		encoder, err := avif.NewAnimationEncoder(buf, &avif.AnimationOptions{Options: avif.Options{Speed: 8}})
+		require.NoError(t, err)
+
+		for i, frame := range frames {
+			require.NoError(t, encoder.AddFrame(frame, delays[i]))
+		}
+		assert.Empty(t, buf.Bytes(), "nothing should be written before Close")
+
+		require.NoError(t, encoder.Close())
+		assert.NotEmpty(t, buf.Bytes())
+	})
+
+	t.Run("validation", func(t *testing.T) {
+		buf := &bytes.Buffer{}
+
+		err := avif.EncodeAnimation(buf, nil, nil, nil)
+		assert.ErrorContains(t, err, "animation has no frames")
+
+		err = avif.EncodeAnimation(buf, frames, delays[:2], nil)
+		assert.ErrorContains(t, err, "got 4 frames but 2 delays")
+
+		err = avif.EncodeAnimation(buf, frames, []time.Duration{0, -time.Second, 0, 0}, nil)
+		assert.ErrorContains(t, err, "frame delay must not be negative")
+
+		mixed := []image.Image{frames[0], image.NewRGBA(image.Rect(0, 0, 8, 8))}
+		err = avif.EncodeAnimation(buf, mixed, delays[:2], nil)
+		assert.ErrorContains(t, err, "frame 1 is 8x8, expected 32x24")
+
+		crop := image.Rect(0, 0, 4, 4)
+		_, err = avif.NewAnimationEncoder(buf, &avif.AnimationOptions{Options: avif.Options{Crop: &crop}})
+		assert.ErrorContains(t, err, "crop is not supported for animations")
+
+		_, err = avif.NewAnimationEncoder(buf, &avif.AnimationOptions{LoopCount: -2})
+		assert.ErrorContains(t, err, "loop count must be -1 or greater")
+
+		err = avif.EncodeAnimation(buf, frames, delays, &avif.AnimationOptions{Options: avif.Options{Speed: 11}})
+		assert.ErrorContains(t, err, "speed must be between 0 and 10")
+
+		assert.Empty(t, buf.Bytes())
+	})
+
+	t.Run("close without frames", func(t *testing.T) {
+		encoder, err := avif.NewAnimationEncoder(&bytes.Buffer{}, nil)
+		require.NoError(t, err)
+
+		assert.ErrorContains(t, encoder.Close(), "animation has no frames")
+	})
+
+	t.Run("writer error", func(t *testing.T) {
+		err := avif.EncodeAnimation(&errorWriter{}, frames, delays, nil)
+
+		assert.ErrorContains(t, err, "failed to write AVIF image")
+	})
+
+	t.Run("cancelled context", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+
+		buf := &bytes.Buffer{}
+		err := avif.EncodeAnimationContext(ctx, buf, frames, delays, nil)
+
+		assert.ErrorIs(t, err, context.Canceled)
+		assert.Empty(t, buf.Bytes())
+	})
+
+	t.Run("cancelled between frames", func(t *testing.T) {
+		buf := &bytes.Buffer{}
+		err := avif.EncodeAnimationContext(newCountdownContext(2), buf, frames, delays, nil)
+
+		assert.ErrorIs(t, err, context.Canceled)
+		assert.Empty(t, buf.Bytes())
+	})
+}
+
+func TestDecodeAll(t *testing.T) {
+	frames := newTestFrames(4, 32, 24)
+	delays := []time.Duration{100 * time.Millisecond, 200 * time.Millisecond, 50 * time.Millisecond, time.Second}
+
+	encode := func(t *testing.T, options *avif.AnimationOptions) []byte {
+		buf := &bytes.Buffer{}
+		require.NoError(t, avif.EncodeAnimation(buf, frames, delays, options))
+		return buf.Bytes()
+	}
+
+	t.Run("frames and timing", func(t *testing.T) {
+		data := encode(t, &avif.AnimationOptions{Options: avif.Options{Speed: 8, ColorQuality: 90, AlphaQuality: 90}})
+
+		animation, err := avif.DecodeAll(bytes.NewReader(data))
+		require.NoError(t, err)
+
+		require.Len(t, animation.Image, len(frames))
+		assert.Equal(t, delays, animation.Delay)
+		assert.Equal(t, 0, animation.LoopCount)
+		require.Len(t, animation.Keyframe, len(frames))
+		assert.True(t, animation.Keyframe[0])
+
+		for i, frame := range animation.Image {
+			assert.Equal(t, frames[i].Bounds().Size(), frame.Bounds().Size())
+
+			// The yellow square moves 4 pixels to the right on every frame
+			r, _, b, _ := frame.At(i*4+4, 4).RGBA()
+			assert.Greater(t, r>>8, uint32(200), "frame %d", i)
+			assert.Less(t, b>>8, uint32(80), "frame %d", i)
+		}
+	})
+
+	t.Run("loop count", func(t *testing.T) {
+		for _, loopCount := range []int{-1, 0, 1, 5} {
+			data := encode(t, &avif.AnimationOptions{Options: avif.Options{Speed: 8}, LoopCount: loopCount})
+
+			animation, err := avif.DecodeAll(bytes.NewReader(data))
+			require.NoError(t, err)
+			assert.Equal(t, loopCount, animation.LoopCount)
+		}
+	})
+
+	t.Run("custom timescale", func(t *testing.T) {
+		data := encode(t, &avif.AnimationOptions{Options: avif.Options{Speed: 8}, Timescale: 90000})
+
+		animation, err := avif.DecodeAll(bytes.NewReader(data))
+		require.NoError(t, err)
+		assert.Equal(t, delays, animation.Delay)
+	})
+
+	t.Run("keyframe interval", func(t *testing.T) {
+		data := encode(t, &avif.AnimationOptions{Options: avif.Options{Speed: 8}, KeyframeInterval: 1})
+
+		animation, err := avif.DecodeAll(bytes.NewReader(data))
+		require.NoError(t, err)
+		assert.Equal(t, []bool{true, true, true, true}, animation.Keyframe)
+	})
+
+	t.Run("still image", func(t *testing.T) {
+		buf := &bytes.Buffer{}
+		require.NoError(t, avif.Encode(buf, frames[0], nil))
+
+		animation, err := avif.DecodeAll(buf)
+		require.NoError(t, err)
+		require.Len(t, animation.Image, 1)
+		assert.Equal(t, []bool{true}, animation.Keyframe)
+	})
+
+	t.Run("invalid data", func(t *testing.T) {
+		_, err := avif.DecodeAll(bytes.NewReader([]byte("not a valid AVIF file")))
+		assert.Error(t, err)
+
+		_, err = avif.DecodeAll(bytes.NewReader(nil))
+		assert.Error(t, err)
+	})
+
+	t.Run("limits", func(t *testing.T) {
+		data := encode(t, &avif.AnimationOptions{Options: avif.Options{Speed: 8}})
+
+		animation, err := avif.DecodeAllWithOptions(bytes.NewReader(data), &avif.DecodeOptions{
+			MaxInputSize: int64(len(data)), MaxDimension: 32, MaxImageCount: 4})
+		require.NoError(t, err)
+		assert.Len(t, animation.Image, len(frames))
+
+		// libavif rejects the sequence while parsing, before reading any frame
+		_, err = avif.DecodeAllWithOptions(bytes.NewReader(data), &avif.DecodeOptions{MaxImageCount: 3})
+		assert.ErrorIs(t, err, avif.ErrTooManyImages)
+		assert.ErrorContains(t, err, "imageCountLimit")
+
+		_, err = avif.DecodeAllWithOptions(bytes.NewReader(data), &avif.DecodeOptions{MaxDimension: 24})
+		assert.ErrorIs(t, err, avif.ErrImageTooLarge)
+
+		_, err = avif.DecodeAllWithOptions(bytes.NewReader(data), &avif.DecodeOptions{MaxPixels: 32*24 - 1})
+		assert.ErrorIs(t, err, avif.ErrImageTooLarge)
+
+		_, err = avif.DecodeAllWithOptions(bytes.NewReader(data),
+			&avif.DecodeOptions{MaxInputSize: int64(len(data)) - 1})
+		assert.ErrorIs(t, err, avif.ErrInputTooLarge)
+
+		_, err = avif.DecodeAllWithOptions(bytes.NewReader(data), &avif.DecodeOptions{MaxImageCount: -1})
+		assert.ErrorContains(t, err, "must not be negative")
+	})
+
+	t.Run("cancelled context", func(t *testing.T) {
+		data := encode(t, nil)
+
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+
+		animation, err := avif.DecodeAllContext(ctx, bytes.NewReader(data), nil)
+		assert.ErrorIs(t, err, context.Canceled)
+		assert.Nil(t, animation)
+
+		animation, err = avif.DecodeAllContext(newCountdownContext(2), bytes.NewReader(data), nil)
+		assert.ErrorIs(t, err, context.Canceled)
+		assert.Nil(t, animation)
+	})
+}
+
+func TestSequenceDecoder(t *testing.T) {
+	frames := newTestFrames(6, 32, 24)
+	delays := make([]time.Duration, len(frames))
+	for i := range delays {
+		delays[i] = time.Duration(i+1) * 10 * time.Millisecond
+	}
+
+	buf := &bytes.Buffer{}
+	options := &avif.AnimationOptions{Options: avif.Options{Speed: 8}, KeyframeInterval: 3, LoopCount: 2}
+	require.NoError(t, avif.EncodeAnimation(buf, frames, delays, options))
+	data := buf.Bytes()
+
+	all, err := avif.DecodeAll(bytes.NewReader(data))
+	require.NoError(t, err)
+
+	t.Run("random access", func(t *testing.T) {
+		sequence, err := avif.NewSequenceDecoder(bytes.NewReader(data))
+		require.NoError(t, err)
+		defer sequence.Close()
+
+		assert.Equal(t, len(frames), sequence.FrameCount())
+		assert.Equal(t, 2, sequence.LoopCount())
+
+		for _, i := range []int{5, 1, 3, 0, 4, 2} {
+			frame, err := sequence.Frame(i)
+			require.NoError(t, err)
+			assert.Equal(t, toRGBA(all.Image[i]).Pix, toRGBA(frame).Pix, "frame %d", i)
+
+			delay, err := sequence.Delay(i)
+			require.NoError(t, err)
+			assert.Equal(t, delays[i], delay)
+		}
+	})
+
+	t.Run("keyframes", func(t *testing.T) {
+		sequence, err := avif.NewSequenceDecoder(bytes.NewReader(data))
+		require.NoError(t, err)
+		defer sequence.Close()
+
+		assert.True(t, sequence.IsKeyframe(0))
+		assert.True(t, sequence.IsKeyframe(3))
+		assert.Equal(t, 0, sequence.NearestKeyframe(0))
+
+		for i := 0; i < sequence.FrameCount(); i++ {
+			nearest := sequence.NearestKeyframe(i)
+			assert.LessOrEqual(t, nearest, i)
+			assert.GreaterOrEqual(t, nearest, i-2, "keyframes are at most 3 frames apart")
+			assert.True(t, sequence.IsKeyframe(nearest))
+			assert.Equal(t, all.Keyframe[i], sequence.IsKeyframe(i))
+		}
+	})
+
+	t.Run("every frame a keyframe", func(t *testing.T) {
+		// libavif omits the sync sample table when every frame is a keyframe, and then seeks from the first frame
+		buf := &bytes.Buffer{}
+		require.NoError(t, avif.EncodeAnimation(buf, frames, delays, &avif.AnimationOptions{
+			Options: avif.Options{Speed: 8}, KeyframeInterval: 1}))
+
+		sequence, err := avif.NewSequenceDecoder(buf)
+		require.NoError(t, err)
+		defer sequence.Close()
+
+		for i := 0; i < sequence.FrameCount(); i++ {
+			assert.True(t, sequence.IsKeyframe(i), "frame %d", i)
+			assert.Equal(t, 0, sequence.NearestKeyframe(i), "frame %d", i)
+		}
+
+		// Seeking backwards restarts from the first frame and still returns the requested one
+		_, err = sequence.Frame(3)
+		require.NoError(t, err)
+		frame, err := sequence.Frame(1)
+		require.NoError(t, err)
+		r, _, b, _ := frame.At(8, 4).RGBA()
+		assert.Greater(t, r>>8, uint32(200))
+		assert.Less(t, b>>8, uint32(80))
+	})
+
+	t.Run("out of range", func(t *testing.T) {
+		sequence, err := avif.NewSequenceDecoder(bytes.NewReader(data))
+		require.NoError(t, err)
+		defer sequence.Close()
+
+		_, err = sequence.Frame(-1)
+		assert.ErrorContains(t, err, "out of range")
+		_, err = sequence.Frame(6)
+		assert.ErrorContains(t, err, "out of range")
+		_, err = sequence.Delay(6)
+		assert.ErrorContains(t, err, "out of range")
+		assert.False(t, sequence.IsKeyframe(6))
+		assert.Equal(t, 0, sequence.NearestKeyframe(6))
+	})
+
+	t.Run("closed", func(t *testing.T) {
+		sequence, err := avif.NewSequenceDecoder(bytes.NewReader(data))
+		require.NoError(t, err)
+		require.NoError(t, sequence.Close())
+		require.NoError(t, sequence.Close())
+
+		assert.Equal(t, 0, sequence.FrameCount())
+		_, err = sequence.Frame(0)
+		assert.ErrorContains(t, err, "sequence decoder is closed")
+	})
+
+	t.Run("options", func(t *testing.T) {
+		_, err := avif.NewSequenceDecoderWithOptions(bytes.NewReader(data), &avif.DecodeOptions{MaxImageCount: 5})
+		assert.ErrorIs(t, err, avif.ErrTooManyImages)
+
+		// Frames of a 10-bit sequence keep their precision with OutputNative, like DecodeWithOptions
+		buf := &bytes.Buffer{}
+		require.NoError(t, avif.EncodeAnimation(buf, frames, delays, &avif.AnimationOptions{
+			Options: avif.Options{Speed: 8, Depth: 10}}))
+
+		sequence, err := avif.NewSequenceDecoderWithOptions(buf, &avif.DecodeOptions{MaxImageCount: 6,
+			Output: avif.OutputNative})
+		require.NoError(t, err)
+		defer sequence.Close()
+
+		frame, err := sequence.Frame(2)
+		require.NoError(t, err)
+		assert.IsType(t, &image.NRGBA64{}, frame)
+		assert.Equal(t, frames[2].Bounds().Size(), frame.Bounds().Size())
+	})
+
+	t.Run("invalid data", func(t *testing.T) {
+		_, err := avif.NewSequenceDecoder(bytes.NewReader([]byte("not a valid AVIF file")))
+		assert.Error(t, err)
+	})
+}
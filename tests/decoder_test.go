@@ -4,11 +4,18 @@ package tests
 
 import (
 	"bytes"
+	"context"
+	"encoding/binary"
 	"errors"
+	"fmt"
 	"image"
+	"image/color"
+	"image/draw"
 	_ "image/jpeg"
+	"io"
 	"os"
 	"testing"
+	"time"
 
 	"github.com/DND-IT/avif-go"
 	"github.com/stretchr/testify/assert"
@@ -51,6 +58,26 @@ func TestDecode(t *testing.T) {
 		assert.NotNil(t, img)
 	})
 
+	t.Run("monochrome image decodes to image.Gray", func(t *testing.T) {
+		// gray.avif is a 64x48 lossless 4:0:0 image whose luma is x*4+y, written by libaom through libavif since
+		// SVT-AV1 cannot encode monochrome images.
+		file, err := os.Open("../assets/gray.avif")
+		require.NoError(t, err)
+		defer file.Close()
+
+		img, err := avif.Decode(file)
+		require.NoError(t, err)
+
+		gray, ok := img.(*image.Gray)
+		require.True(t, ok, "expected *image.Gray, got %T", img)
+		require.Equal(t, image.Rect(0, 0, 64, 48), gray.Bounds())
+		for y := 0; y < 48; y++ {
+			for x := 0; x < 64; x++ {
+				assert.Equal(t, uint8(x*4+y), gray.GrayAt(x, y).Y, "pixel %d,%d", x, y)
+			}
+		}
+	})
+
 	t.Run("reader error", func(t *testing.T) {
 		errReader := &errorReader{err: errors.New("read error")}
 
@@ -108,6 +135,55 @@ func TestDecode(t *testing.T) {
 	})
 }
 
+func TestDecodeLayers(t *testing.T) {
+	t.Run("progressive image", func(t *testing.T) {
+		// progressive.avif is a 128x96 image made of one pixel wide vertical stripes, stored as two libaom spatial
+		// layers: a preview coded at half size, which cannot hold the stripes, then the full size image.
+		data, err := os.ReadFile("../assets/progressive.avif")
+		require.NoError(t, err)
+
+		layers, err := avif.DecodeLayers(bytes.NewReader(data))
+		require.NoError(t, err)
+		require.Len(t, layers, 2)
+		for _, layer := range layers {
+			assert.Equal(t, image.Rect(0, 0, 128, 96), layer.Bounds())
+		}
+
+		// The preview comes first and has lost the stripes, which the last layer restores
+		assert.Less(t, stripeContrast(layers[0]), 16.0)
+		assert.Greater(t, stripeContrast(layers[1]), 96.0)
+
+		// Regular decoding returns the last layer
+		full, err := avif.Decode(bytes.NewReader(data))
+		require.NoError(t, err)
+		assert.Equal(t, toRGBA(full).Pix, toRGBA(layers[1]).Pix)
+	})
+
+	t.Run("regular image has a single layer", func(t *testing.T) {
+		buf := &bytes.Buffer{}
+		require.NoError(t, avif.Encode(buf, image.NewRGBA(image.Rect(0, 0, 64, 64)), nil))
+
+		layers, err := avif.DecodeLayers(buf)
+		require.NoError(t, err)
+		assert.Len(t, layers, 1)
+	})
+}
+
+// stripeContrast returns the average difference in green between horizontally adjacent pixels of img.
+func stripeContrast(img image.Image) float64 {
+	bounds := img.Bounds()
+	sum := 0
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X + 1; x < bounds.Max.X; x++ {
+			_, g0, _, _ := img.At(x-1, y).RGBA()
+			_, g1, _, _ := img.At(x, y).RGBA()
+			d := int(g1>>8) - int(g0>>8)
+			sum += max(d, -d)
+		}
+	}
+	return float64(sum) / float64((bounds.Dx()-1)*bounds.Dy())
+}
+
 func TestDecodeConfig(t *testing.T) {
 	t.Run("valid AVIF file", func(t *testing.T) {
 		if _, err := os.Stat("../assets/image.avif"); err != nil {
@@ -178,6 +254,161 @@ func TestDecodeConfig(t *testing.T) {
 	})
 }
 
+func TestInspect(t *testing.T) {
+	t.Run("valid AVIF file", func(t *testing.T) {
+		if _, err := os.Stat("../assets/image.avif"); err != nil {
+			t.Skip("assets/image.avif not found, skipping test")
+			return
+		}
+
+		file, err := os.Open("../assets/image.avif")
+		require.NoError(t, err)
+		defer file.Close()
+
+		info, err := avif.Inspect(file)
+		require.NoError(t, err)
+		assert.Equal(t, 1024, info.Width)
+		assert.Equal(t, 1536, info.Height)
+		assert.Equal(t, 8, info.Depth)
+		assert.Equal(t, avif.ChromaSubsampling420, info.ChromaSubsampling)
+		assert.False(t, info.HasAlpha)
+		assert.False(t, info.Sequence)
+		assert.Equal(t, 1, info.ImageCount)
+		assert.Zero(t, info.Duration)
+		assert.False(t, info.Progressive)
+		assert.Zero(t, info.GridColumns)
+		assert.Zero(t, info.GridRows)
+
+		_, err = file.Seek(0, io.SeekStart)
+		require.NoError(t, err)
+		config, err := avif.DecodeConfig(file)
+		require.NoError(t, err)
+		assert.Equal(t, color.RGBAModel, config.ColorModel)
+	})
+
+	t.Run("encoded properties", func(t *testing.T) {
+		img := image.NewNRGBA64(image.Rect(0, 0, 32, 16))
+		for i := range img.Pix {
+			img.Pix[i] = uint8(i)
+		}
+		exif := []byte("Exif\x00\x00MM\x00\x2a\x00\x00\x00\x08\x00\x00")
+		colorInfo := &avif.ColorInfo{
+			ColorPrimaries:          avif.ColorPrimariesBT2020,
+			TransferCharacteristics: avif.TransferCharacteristicsPQ,
+			MatrixCoefficients:      avif.MatrixCoefficientsBT2020NCL,
+		}
+
+		buf := &bytes.Buffer{}
+		require.NoError(t, avif.Encode(buf, img, &avif.Options{Speed: 8, ColorQuality: 80, AlphaQuality: 80, Depth: 10,
+			Color: colorInfo, EXIF: exif, Orientation: 6}))
+
+		info, err := avif.Inspect(bytes.NewReader(buf.Bytes()))
+		require.NoError(t, err)
+		assert.Equal(t, 16, info.Width)
+		assert.Equal(t, 32, info.Height)
+		assert.Equal(t, 10, info.Depth)
+		assert.Equal(t, avif.ChromaSubsampling420, info.ChromaSubsampling)
+		assert.Equal(t, *colorInfo, info.Color)
+		assert.True(t, info.HasAlpha)
+		assert.Equal(t, 6, info.Transform.Orientation)
+		assert.Equal(t, len(exif), info.EXIFSize)
+		assert.Zero(t, info.XMPSize)
+		assert.Zero(t, info.ICCProfileSize)
+
+		config, err := avif.DecodeConfig(bytes.NewReader(buf.Bytes()))
+		require.NoError(t, err)
+		assert.Equal(t, color.NRGBAModel, config.ColorModel)
+	})
+
+	t.Run("grayscale", func(t *testing.T) {
+		buf := &bytes.Buffer{}
+		require.NoError(t, avif.Encode(buf, image.NewGray(image.Rect(0, 0, 16, 16)), &avif.Options{Speed: 8}))
+
+		// SVT-AV1 cannot encode 4:0:0, so grayscale images are stored as 4:2:0 with neutral chroma
+		info, err := avif.Inspect(bytes.NewReader(buf.Bytes()))
+		require.NoError(t, err)
+		assert.Equal(t, avif.ChromaSubsampling420, info.ChromaSubsampling)
+		assert.False(t, info.HasAlpha)
+
+		config, err := avif.DecodeConfig(bytes.NewReader(buf.Bytes()))
+		require.NoError(t, err)
+		assert.Equal(t, color.RGBAModel, config.ColorModel)
+	})
+
+	t.Run("monochrome", func(t *testing.T) {
+		data, err := os.ReadFile("../assets/gray.avif")
+		require.NoError(t, err)
+
+		info, err := avif.Inspect(bytes.NewReader(data))
+		require.NoError(t, err)
+		assert.Equal(t, avif.ChromaSubsampling400, info.ChromaSubsampling)
+
+		config, err := avif.DecodeConfig(bytes.NewReader(data))
+		require.NoError(t, err)
+		assert.Equal(t, color.GrayModel, config.ColorModel)
+	})
+
+	t.Run("image sequence", func(t *testing.T) {
+		delays := []time.Duration{100 * time.Millisecond, 200 * time.Millisecond, 300 * time.Millisecond}
+		buf := &bytes.Buffer{}
+		require.NoError(t, avif.EncodeAnimation(buf, newTestFrames(3, 32, 24), delays, &avif.AnimationOptions{
+			Options: avif.Options{Speed: 8}, LoopCount: 2}))
+
+		info, err := avif.Inspect(buf)
+		require.NoError(t, err)
+		assert.True(t, info.Sequence)
+		assert.Equal(t, 3, info.ImageCount)
+		assert.Equal(t, 600*time.Millisecond, info.Duration)
+		assert.Equal(t, 2, info.LoopCount)
+	})
+
+	t.Run("grid layout", func(t *testing.T) {
+		// One pixel wider than SVT-AV1 supports, so the image is split into two cells
+		buf := &bytes.Buffer{}
+		require.NoError(t, avif.Encode(buf, image.NewRGBA(image.Rect(0, 0, 16385, 64)), &avif.Options{Speed: 10}))
+
+		info, err := avif.Inspect(buf)
+		require.NoError(t, err)
+		assert.Equal(t, 2, info.GridColumns)
+		assert.Equal(t, 1, info.GridRows)
+		assert.Equal(t, 16385, info.Width)
+		assert.Equal(t, 64, info.Height)
+		assert.Equal(t, 1, info.ImageCount)
+	})
+
+	t.Run("oversized box", func(t *testing.T) {
+		buf := &bytes.Buffer{}
+		require.NoError(t, avif.Encode(buf, newBlockImage(256, 256), &avif.Options{Speed: 10, GridCellWidth: 128,
+			GridCellHeight: 128}))
+
+		// libavif stops parsing once it has the metadata, so only the grid layout reader sees a trailing box whose
+		// 64-bit largesize is past the end of the file, or overflows its offset
+		for _, largesize := range []uint64{1 << 63, 1<<63 - 1} {
+			data := append([]byte{}, buf.Bytes()...)
+			data = append(data, 0, 0, 0, 1, 'f', 'r', 'e', 'e')
+			data = binary.BigEndian.AppendUint64(data, largesize)
+
+			info, err := avif.Inspect(bytes.NewReader(data))
+			require.NoError(t, err, "largesize %d", largesize)
+			assert.Equal(t, 256, info.Width)
+			assert.Equal(t, 256, info.Height)
+			assert.Zero(t, info.GridColumns, "the grid layout is not read from a file with an invalid box")
+		}
+	})
+
+	t.Run("invalid data", func(t *testing.T) {
+		_, err := avif.Inspect(bytes.NewReader([]byte("not a valid AVIF file")))
+
+		assert.ErrorIs(t, err, avif.ErrInvalidData)
+	})
+
+	t.Run("reader error", func(t *testing.T) {
+		_, err := avif.Inspect(&errorReader{err: errors.New("read error")})
+
+		assert.ErrorContains(t, err, "read error")
+	})
+}
+
 func TestMultipleFormats(t *testing.T) {
 	if _, err := os.Stat("../assets/image.avif"); err != nil {
 		t.Skip("assets/image.avif not found, skipping test")
@@ -212,6 +443,501 @@ func TestMultipleFormats(t *testing.T) {
 	})
 }
 
+func TestIncrementalDecoder(t *testing.T) {
+	img := image.NewRGBA(image.Rect(0, 0, 96, 64))
+	for i := range img.Pix {
+		img.Pix[i] = uint8(i * 7)
+	}
+	for i := 3; i < len(img.Pix); i += 4 {
+		img.Pix[i] = 255
+	}
+
+	buf := &bytes.Buffer{}
+	require.NoError(t, avif.Encode(buf, img, &avif.Options{Speed: 8, ColorQuality: 80, AlphaQuality: 80}))
+	data := buf.Bytes()
+
+	full, err := avif.Decode(bytes.NewReader(data))
+	require.NoError(t, err)
+
+	t.Run("chunked input", func(t *testing.T) {
+		decoder, err := avif.NewIncrementalDecoder(nil)
+		require.NoError(t, err)
+		defer decoder.Close()
+
+		lastRows := 0
+		for offset := 0; offset < len(data); offset += 100 {
+			_, err = decoder.Write(data[offset:min(offset+100, len(data))])
+			require.NoError(t, err)
+
+			rows, err := decoder.Decode()
+			require.NoError(t, err)
+			assert.GreaterOrEqual(t, rows, lastRows)
+			lastRows = rows
+
+			if offset+100 < len(data) {
+				assert.False(t, decoder.Done())
+			}
+		}
+
+		assert.True(t, decoder.Done())
+		assert.Equal(t, 64, lastRows)
+		assert.Equal(t, image.Rect(0, 0, 96, 64), decoder.Bounds())
+
+		decoded, err := decoder.Image()
+		require.NoError(t, err)
+		assert.Equal(t, toRGBA(full).Pix, toRGBA(decoded).Pix)
+	})
+
+	t.Run("grid image", func(t *testing.T) {
+		// 2x2 cells of 128x128 pixels, which become available one row of cells at a time
+		grid := &bytes.Buffer{}
+		require.NoError(t, avif.Encode(grid, newNoiseImage(256, 256), &avif.Options{Speed: 8, ColorQuality: 80,
+			AlphaQuality: 80, GridCellWidth: 128, GridCellHeight: 128}))
+		gridData := grid.Bytes()
+
+		decoder, err := avif.NewIncrementalDecoder(nil)
+		require.NoError(t, err)
+		defer decoder.Close()
+
+		partialRows := 0
+		for offset := 0; offset < len(gridData); offset += 256 {
+			_, err = decoder.Write(gridData[offset:min(offset+256, len(gridData))])
+			require.NoError(t, err)
+
+			rows, err := decoder.Decode()
+			require.NoError(t, err)
+			if rows > 0 && !decoder.Done() {
+				partialRows = rows
+				partial, err := decoder.Image()
+				require.NoError(t, err)
+				assert.Equal(t, image.Rect(0, 0, 256, 256), partial.Bounds())
+			}
+		}
+
+		assert.True(t, decoder.Done())
+		assert.Greater(t, partialRows, 0, "no rows were decoded before the last cell")
+		assert.Less(t, partialRows, 256)
+
+		decoded, err := decoder.Image()
+		require.NoError(t, err)
+		want, err := avif.Decode(bytes.NewReader(gridData))
+		require.NoError(t, err)
+		assert.Equal(t, toRGBA(want).Pix, toRGBA(decoded).Pix)
+	})
+
+	t.Run("waiting for data", func(t *testing.T) {
+		decoder, err := avif.NewIncrementalDecoder(nil)
+		require.NoError(t, err)
+		defer decoder.Close()
+
+		rows, err := decoder.Decode()
+		require.NoError(t, err)
+		assert.Equal(t, 0, rows)
+		assert.Equal(t, image.Rectangle{}, decoder.Bounds())
+
+		_, err = decoder.Image()
+		assert.ErrorContains(t, err, "no rows decoded yet")
+	})
+
+	t.Run("truncated input", func(t *testing.T) {
+		decoder, err := avif.NewIncrementalDecoder(nil)
+		require.NoError(t, err)
+		defer decoder.Close()
+
+		_, err = decoder.Write(data[:len(data)/2])
+		require.NoError(t, err)
+		decoder.CloseWrite()
+
+		_, err = decoder.Decode()
+		assert.Error(t, err)
+		assert.False(t, decoder.Done())
+
+		_, err = decoder.Write(data[len(data)/2:])
+		assert.ErrorContains(t, err, "cannot write after CloseWrite")
+	})
+
+	t.Run("invalid data", func(t *testing.T) {
+		decoder, err := avif.NewIncrementalDecoder(nil)
+		require.NoError(t, err)
+		defer decoder.Close()
+
+		_, err = decoder.Write([]byte("not a valid AVIF file, not even close to it"))
+		require.NoError(t, err)
+		decoder.CloseWrite()
+
+		_, err = decoder.Decode()
+		assert.Error(t, err)
+	})
+
+	t.Run("closed", func(t *testing.T) {
+		decoder, err := avif.NewIncrementalDecoder(nil)
+		require.NoError(t, err)
+		require.NoError(t, decoder.Close())
+
+		_, err = decoder.Write(data)
+		assert.ErrorContains(t, err, "incremental decoder is closed")
+		_, err = decoder.Decode()
+		assert.ErrorContains(t, err, "incremental decoder is closed")
+	})
+}
+
+func TestDecodeWithOptions_Limits(t *testing.T) {
+	data, err := os.ReadFile("../assets/image.avif")
+	if err != nil {
+		t.Skip("assets/image.avif not found, skipping test")
+		return
+	}
+
+	tests := []struct {
+		name    string
+		options avif.DecodeOptions
+		err     error
+	}{
+		{"within limits", avif.DecodeOptions{MaxInputSize: int64(len(data)), MaxPixels: 1024 * 1536, MaxDimension: 1536,
+			MaxImageCount: 1}, nil},
+		{"input too large", avif.DecodeOptions{MaxInputSize: int64(len(data)) - 1}, avif.ErrInputTooLarge},
+		{"too many pixels", avif.DecodeOptions{MaxPixels: 1024*1536 - 1}, avif.ErrImageTooLarge},
+		{"dimension too large", avif.DecodeOptions{MaxDimension: 1024}, avif.ErrImageTooLarge},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Both the seekable and the in-memory paths must enforce the limits
+			readers := []io.Reader{bytes.NewReader(data), bytes.NewBuffer(data)}
+			for _, reader := range readers {
+				img, _, err := avif.DecodeWithOptions(reader, &tt.options)
+				if tt.err == nil {
+					require.NoError(t, err)
+					assert.Equal(t, image.Rect(0, 0, 1024, 1536), img.Bounds())
+					continue
+				}
+				assert.ErrorIs(t, err, tt.err)
+				assert.Nil(t, img)
+			}
+		})
+	}
+
+	t.Run("rejected while parsing", func(t *testing.T) {
+		// libavif checks the limits against the image properties, before reading the AV1 payload
+		_, _, err := avif.DecodeWithOptions(bytes.NewReader(data), &avif.DecodeOptions{MaxDimension: 1024})
+		assert.ErrorIs(t, err, avif.ErrImageTooLarge)
+		assert.ErrorContains(t, err, "dimensions are too large")
+		assert.NotErrorIs(t, err, avif.ErrInvalidData)
+	})
+
+	t.Run("incremental", func(t *testing.T) {
+		decoder, err := avif.NewIncrementalDecoder(&avif.DecodeOptions{MaxDimension: 1024})
+		require.NoError(t, err)
+		defer decoder.Close()
+
+		_, err = decoder.Write(data)
+		require.NoError(t, err)
+		_, err = decoder.Decode()
+		assert.ErrorIs(t, err, avif.ErrImageTooLarge)
+	})
+
+	t.Run("incremental input too large", func(t *testing.T) {
+		decoder, err := avif.NewIncrementalDecoder(&avif.DecodeOptions{MaxInputSize: 100})
+		require.NoError(t, err)
+		defer decoder.Close()
+
+		_, err = decoder.Write(data[:100])
+		require.NoError(t, err)
+		_, err = decoder.Write(data[100:101])
+		assert.ErrorIs(t, err, avif.ErrInputTooLarge)
+	})
+
+	t.Run("invalid options", func(t *testing.T) {
+		_, _, err := avif.DecodeWithOptions(bytes.NewReader(data), &avif.DecodeOptions{MaxPixels: -1})
+		assert.ErrorContains(t, err, "must not be negative")
+
+		_, _, err = avif.DecodeWithOptions(bytes.NewReader(data), &avif.DecodeOptions{RelaxedChecks: 1 << 8})
+		assert.ErrorContains(t, err, "unknown strict flags")
+
+		_, _, err = avif.DecodeWithOptions(bytes.NewReader(data), &avif.DecodeOptions{Threads: -1})
+		assert.ErrorContains(t, err, "threads must not be negative")
+	})
+}
+
+func TestDecodeWithOptions_Output(t *testing.T) {
+	native := &avif.DecodeOptions{Output: avif.OutputNative}
+
+	t.Run("opaque 8-bit image as YCbCr", func(t *testing.T) {
+		data, err := os.ReadFile("../assets/image.avif")
+		if err != nil {
+			t.Skip("assets/image.avif not found, skipping test")
+			return
+		}
+
+		img, _, err := avif.DecodeWithOptions(bytes.NewReader(data), native)
+		require.NoError(t, err)
+		ycbcr, ok := img.(*image.YCbCr)
+		require.True(t, ok, "expected *image.YCbCr, got %T", img)
+		assert.Equal(t, image.YCbCrSubsampleRatio420, ycbcr.SubsampleRatio)
+
+		// Go and libavif upsample the chroma differently, so only the average difference is small
+		rgba, err := avif.Decode(bytes.NewReader(data))
+		require.NoError(t, err)
+		assert.Less(t, meanDifference(toRGBA(img), toRGBA(rgba)), 2.0)
+	})
+
+	t.Run("monochrome image as Gray", func(t *testing.T) {
+		data, err := os.ReadFile("../assets/gray.avif")
+		require.NoError(t, err)
+
+		img, _, err := avif.DecodeWithOptions(bytes.NewReader(data), native)
+		require.NoError(t, err)
+		gray, ok := img.(*image.Gray)
+		require.True(t, ok, "expected *image.Gray, got %T", img)
+		assert.Equal(t, uint8(17*4+9), gray.GrayAt(17, 9).Y)
+	})
+
+	t.Run("rotated image falls back to RGBA", func(t *testing.T) {
+		buf := &bytes.Buffer{}
+		require.NoError(t, avif.Encode(buf, newTestImage(32, 16), &avif.Options{Speed: 8, ColorQuality: 80,
+			AlphaQuality: 80, Orientation: 6}))
+
+		img, _, err := avif.DecodeWithOptions(buf, native)
+		require.NoError(t, err)
+		assert.IsType(t, &image.RGBA{}, img)
+		assert.Equal(t, image.Rect(0, 0, 16, 32), img.Bounds())
+	})
+
+	t.Run("high bit depth", func(t *testing.T) {
+		opaque := image.NewRGBA64(image.Rect(0, 0, 32, 32))
+		translucent := image.NewNRGBA64(image.Rect(0, 0, 32, 32))
+		gray := image.NewGray16(image.Rect(0, 0, 32, 32))
+		for y := 0; y < 32; y++ {
+			for x := 0; x < 32; x++ {
+				v := uint16(x * 2048)
+				opaque.SetRGBA64(x, y, color.RGBA64{R: v, G: 0x8000, B: uint16(y * 2048), A: 0xffff})
+				translucent.SetNRGBA64(x, y, color.NRGBA64{R: v, G: 0x8000, B: 0x4000, A: uint16(y * 2048)})
+				gray.SetGray16(x, y, color.Gray16{Y: v})
+			}
+		}
+
+		tests := []struct {
+			name string
+			img  image.Image
+			want image.Image
+		}{
+			{"opaque", opaque, &image.RGBA64{}},
+			{"with alpha", translucent, &image.NRGBA64{}},
+			{"grayscale source", gray, &image.RGBA64{}},
+		}
+
+		for _, tt := range tests {
+			t.Run(tt.name, func(t *testing.T) {
+				buf := &bytes.Buffer{}
+				require.NoError(t, avif.Encode(buf, tt.img, &avif.Options{Speed: 8, ColorQuality: 90, AlphaQuality: 90,
+					Depth: 10}))
+
+				img, _, err := avif.DecodeWithOptions(buf, native)
+				require.NoError(t, err)
+				assert.IsType(t, tt.want, img)
+
+				r1, g1, b1, a1 := img.At(17, 9).RGBA()
+				r2, g2, b2, a2 := tt.img.At(17, 9).RGBA()
+				for i, pair := range [][2]uint32{{r1, r2}, {g1, g2}, {b1, b2}, {a1, a2}} {
+					assert.InDelta(t, pair[1], pair[0], 0x400, "channel %d", i)
+				}
+			})
+		}
+	})
+
+	t.Run("default output is 8-bit", func(t *testing.T) {
+		// A zero image.RGBA64 is fully transparent and would decode as NRGBA, so fill it with an opaque colour
+		src := image.NewRGBA64(image.Rect(0, 0, 16, 16))
+		draw.Draw(src, src.Bounds(), image.NewUniform(color.RGBA64{R: 0x8000, G: 0x4000, B: 0x2000, A: 0xffff}),
+			image.Point{}, draw.Src)
+
+		buf := &bytes.Buffer{}
+		require.NoError(t, avif.Encode(buf, src, &avif.Options{Speed: 8, Depth: 10, ColorQuality: 80,
+			AlphaQuality: 80}))
+
+		img, _, err := avif.DecodeWithOptions(buf, nil)
+		require.NoError(t, err)
+		assert.IsType(t, &image.RGBA{}, img)
+	})
+}
+
+func TestDecodeContext(t *testing.T) {
+	buf := &bytes.Buffer{}
+	require.NoError(t, avif.Encode(buf, newTestImage(32, 32), &avif.Options{Speed: 8, ColorQuality: 60}))
+	data := buf.Bytes()
+
+	t.Run("completes with a live context", func(t *testing.T) {
+		img, metadata, err := avif.DecodeContext(context.Background(), bytes.NewReader(data), nil)
+		require.NoError(t, err)
+		assert.Equal(t, image.Rect(0, 0, 32, 32), img.Bounds())
+		assert.NotNil(t, metadata)
+	})
+
+	t.Run("cancelled context", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+
+		img, metadata, err := avif.DecodeContext(ctx, bytes.NewReader(data), nil)
+		assert.ErrorIs(t, err, context.Canceled)
+		assert.Nil(t, img)
+		assert.Nil(t, metadata)
+	})
+
+	// The context is checked before parsing, decoding and converting the image
+	for checks := 1; checks <= 2; checks++ {
+		t.Run(fmt.Sprintf("cancelled after %d checks", checks), func(t *testing.T) {
+			img, _, err := avif.DecodeContext(newCountdownContext(checks), bytes.NewReader(data), nil)
+			assert.ErrorIs(t, err, context.Canceled)
+			assert.Nil(t, img)
+		})
+	}
+}
+
+func TestDecode_Errors(t *testing.T) {
+	t.Run("invalid data", func(t *testing.T) {
+		_, err := avif.Decode(bytes.NewReader([]byte("not a valid AVIF file")))
+		assert.ErrorIs(t, err, avif.ErrInvalidData)
+		assert.NotErrorIs(t, err, avif.ErrUnsupported)
+
+		var avifErr *avif.Error
+		require.ErrorAs(t, err, &avifErr)
+		assert.Equal(t, avif.OpParse, avifErr.Op)
+		assert.NotEmpty(t, avifErr.Result.String())
+	})
+
+	t.Run("empty data", func(t *testing.T) {
+		_, err := avif.Decode(bytes.NewReader(nil))
+		assert.ErrorIs(t, err, avif.ErrInvalidData)
+
+		_, err = avif.DecodeConfig(bytes.NewReader(nil))
+		assert.ErrorIs(t, err, avif.ErrInvalidData)
+	})
+
+	t.Run("truncated data", func(t *testing.T) {
+		data, err := os.ReadFile("../assets/image.avif")
+		if err != nil {
+			t.Skip("assets/image.avif not found, skipping test")
+			return
+		}
+
+		_, err = avif.Decode(bytes.NewBuffer(data[:len(data)/2]))
+		assert.ErrorIs(t, err, avif.ErrInvalidData)
+	})
+
+	t.Run("read error is not an AVIF error", func(t *testing.T) {
+		_, err := avif.Decode(&errorReader{err: errors.New("read error")})
+
+		var avifErr *avif.Error
+		assert.False(t, errors.As(err, &avifErr))
+		assert.NotErrorIs(t, err, avif.ErrInvalidData)
+	})
+}
+
+func TestDecode_Streaming(t *testing.T) {
+	img := newNoiseImage(96, 64)
+	buf := &bytes.Buffer{}
+	require.NoError(t, avif.Encode(buf, img, &avif.Options{Speed: 8, ColorQuality: 80, AlphaQuality: 80}))
+	data := buf.Bytes()
+
+	full, err := avif.Decode(bytes.NewBuffer(data))
+	require.NoError(t, err)
+
+	t.Run("seeker without ReaderAt", func(t *testing.T) {
+		decoded, err := avif.Decode(&seekReader{data: data})
+		require.NoError(t, err)
+		assert.Equal(t, toRGBA(full).Pix, toRGBA(decoded).Pix)
+	})
+
+	t.Run("from current position", func(t *testing.T) {
+		reader := bytes.NewReader(append([]byte("prefix"), data...))
+		_, err := reader.Seek(6, io.SeekStart)
+		require.NoError(t, err)
+
+		decoded, err := avif.Decode(reader)
+		require.NoError(t, err)
+		assert.Equal(t, toRGBA(full).Pix, toRGBA(decoded).Pix)
+	})
+
+	t.Run("config reads only the header", func(t *testing.T) {
+		reader := &seekReader{data: data}
+
+		config, err := avif.DecodeConfig(reader)
+		require.NoError(t, err)
+		assert.Equal(t, 96, config.Width)
+		assert.Equal(t, 64, config.Height)
+		assert.Less(t, reader.read, len(data)/2)
+	})
+
+	t.Run("read error", func(t *testing.T) {
+		readErr := errors.New("read error")
+		_, err := avif.Decode(&seekReader{data: data, failAfter: len(data) / 2, err: readErr})
+		assert.ErrorIs(t, err, readErr)
+	})
+
+	t.Run("pipe that cannot seek", func(t *testing.T) {
+		// *os.File implements io.Seeker, but seeking a pipe fails, so it is read into memory instead
+		pipe := func(t *testing.T) *os.File {
+			r, w, err := os.Pipe()
+			require.NoError(t, err)
+			t.Cleanup(func() { r.Close() })
+
+			go func() {
+				w.Write(data)
+				w.Close()
+			}()
+			return r
+		}
+
+		decoded, err := avif.Decode(pipe(t))
+		require.NoError(t, err)
+		assert.Equal(t, toRGBA(full).Pix, toRGBA(decoded).Pix)
+
+		config, err := avif.DecodeConfig(pipe(t))
+		require.NoError(t, err)
+		assert.Equal(t, 96, config.Width)
+
+		_, err = avif.Inspect(pipe(t))
+		require.NoError(t, err)
+
+		_, _, err = avif.DecodeWithOptions(pipe(t), &avif.DecodeOptions{MaxInputSize: int64(len(data) - 1)})
+		assert.ErrorIs(t, err, avif.ErrInputTooLarge)
+	})
+}
+
+// seekReader is a helper io.ReadSeeker that does not implement io.ReaderAt. It counts the bytes read, and fails reads
+// past failAfter when it is set.
+type seekReader struct {
+	data      []byte
+	offset    int
+	read      int
+	failAfter int
+	err       error
+}
+
+func (r *seekReader) Read(p []byte) (int, error) {
+	if r.offset >= len(r.data) {
+		return 0, io.EOF
+	}
+	if r.failAfter > 0 && r.offset+len(p) > r.failAfter {
+		return 0, r.err
+	}
+	n := copy(p, r.data[r.offset:])
+	r.offset += n
+	r.read += n
+	return n, nil
+}
+
+func (r *seekReader) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekCurrent:
+		offset += int64(r.offset)
+	case io.SeekEnd:
+		offset += int64(len(r.data))
+	}
+	r.offset = int(offset)
+	return offset, nil
+}
+
 // errorReader is a helper type that always returns an error on Read
 type errorReader struct {
 	err error
@@ -2,12 +2,16 @@ package tests
 
 import (
 	"bytes"
+	"context"
 	"errors"
+	"fmt"
 	"image"
 	"image/color"
+	"image/draw"
 	_ "image/jpeg" // Register JPEG format
 	"os"
 	"testing"
+	"time"
 
 	"github.com/DND-IT/avif-go"
 	"github.com/stretchr/testify/assert"
@@ -38,6 +42,24 @@ func TestEncode_Options(t *testing.T) {
 		assert.NoError(t, err)
 		assert.NotEmpty(t, buf.Bytes())
 	})
+
+	t.Run("threads", func(t *testing.T) {
+		photo := newBlockImage(64, 48)
+		for _, threads := range []int{1, 2, 8} {
+			buf := &bytes.Buffer{}
+			require.NoError(t, avif.Encode(buf, photo, &avif.Options{Speed: 8, ColorQuality: 90, AlphaQuality: 90,
+				Threads: threads}))
+
+			// Decoding gives the same pixels whatever the number of threads
+			single, _, err := avif.DecodeWithOptions(bytes.NewReader(buf.Bytes()), &avif.DecodeOptions{Threads: 1})
+			require.NoError(t, err)
+			decoded, _, err := avif.DecodeWithOptions(bytes.NewReader(buf.Bytes()), &avif.DecodeOptions{
+				Threads: threads})
+			require.NoError(t, err)
+			assert.Equal(t, toRGBA(single).Pix, toRGBA(decoded).Pix, "%d threads", threads)
+			assert.Equal(t, photo.Bounds(), decoded.Bounds())
+		}
+	})
 }
 
 func TestEncode_Validation(t *testing.T) {
@@ -100,6 +122,11 @@ func TestEncode_Validation(t *testing.T) {
 		}
 	})
 
+	t.Run("threads validation", func(t *testing.T) {
+		err := avif.Encode(buf, img, &avif.Options{Speed: 6, AlphaQuality: 60, ColorQuality: 60, Threads: -1})
+		assert.ErrorContains(t, err, "threads must not be negative")
+	})
+
 	t.Run("color quality validation", func(t *testing.T) {
 		tests := []struct {
 			name         string
@@ -178,6 +205,403 @@ func TestEncode_WithRealImage(t *testing.T) {
 	}
 }
 
+func TestEncode_Alpha(t *testing.T) {
+	// A colour gradient whose alpha fades from transparent on the left to opaque on the right
+	straight := image.NewNRGBA(image.Rect(0, 0, 256, 32))
+	for y := 0; y < 32; y++ {
+		for x := 0; x < 256; x++ {
+			straight.SetNRGBA(x, y, color.NRGBA{R: 200, G: uint8(255 - x), B: uint8(y * 8), A: uint8(x)})
+		}
+	}
+
+	t.Run("straight alpha round trips", func(t *testing.T) {
+		decoded := encodeDecode(t, straight, &avif.Options{Speed: 8, ColorQuality: 95, AlphaQuality: 100})
+
+		nrgba, ok := decoded.(*image.NRGBA)
+		require.True(t, ok, "expected *image.NRGBA, got %T", decoded)
+		// The colour of barely visible pixels is kept rather than lost to premultiplication
+		for _, x := range []int{8, 64, 128, 248} {
+			want := straight.NRGBAAt(x, 16)
+			got := nrgba.NRGBAAt(x, 16)
+			assert.InDelta(t, want.R, got.R, 8, "red at %d", x)
+			assert.InDelta(t, want.G, got.G, 8, "green at %d", x)
+			assert.InDelta(t, want.B, got.B, 8, "blue at %d", x)
+			assert.InDelta(t, want.A, got.A, 2, "alpha at %d", x)
+		}
+	})
+
+	t.Run("premultiplied input", func(t *testing.T) {
+		premultiplied := toRGBA(straight)
+		decoded := toRGBA(encodeDecode(t, premultiplied, &avif.Options{Speed: 8, ColorQuality: 95, AlphaQuality: 100}))
+
+		assert.Less(t, meanDifference(premultiplied, decoded), 3.0)
+	})
+
+	t.Run("colour is not darkened", func(t *testing.T) {
+		img := image.NewNRGBA(image.Rect(0, 0, 64, 64))
+		for i := 0; i < len(img.Pix); i += 4 {
+			copy(img.Pix[i:i+4], []byte{200, 100, 50, 128})
+		}
+
+		decoded := encodeDecode(t, img, &avif.Options{Speed: 8, ColorQuality: 90, AlphaQuality: 90})
+
+		c := color.NRGBAModel.Convert(decoded.At(32, 32)).(color.NRGBA)
+		assert.InDelta(t, 200, c.R, 4)
+		assert.InDelta(t, 100, c.G, 4)
+		assert.InDelta(t, 50, c.B, 4)
+		assert.InDelta(t, 128, c.A, 4)
+	})
+
+	t.Run("opaque image decodes to image.RGBA", func(t *testing.T) {
+		decoded := encodeDecode(t, newTestImage(32, 32), &avif.Options{Speed: 8, ColorQuality: 60, AlphaQuality: 60})
+
+		_, ok := decoded.(*image.RGBA)
+		assert.True(t, ok, "expected *image.RGBA, got %T", decoded)
+	})
+}
+
+func TestEncode_Depth(t *testing.T) {
+	t.Run("depth validation", func(t *testing.T) {
+		img := image.NewRGBA(image.Rect(0, 0, 10, 10))
+		tests := []struct {
+			name    string
+			depth   int
+			wantErr string
+		}{
+			{"depth 0", 0, ""},
+			{"depth 8", 8, ""},
+			{"depth 9", 9, "depth must be 8 or 10"},
+			{"depth 10", 10, ""},
+			{"depth 12", 12, "12-bit encoding is not supported"},
+			{"depth 16", 16, "depth must be 8 or 10"},
+		}
+
+		for _, tt := range tests {
+			t.Run(tt.name, func(t *testing.T) {
+				buf := &bytes.Buffer{}
+				err := avif.Encode(buf, img, &avif.Options{Speed: 8, AlphaQuality: 60, ColorQuality: 60,
+					Depth: tt.depth})
+
+				if tt.wantErr != "" {
+					assert.Error(t, err)
+					assert.Contains(t, err.Error(), tt.wantErr)
+					if tt.depth == 12 {
+						assert.ErrorIs(t, err, avif.ErrUnsupported)
+					}
+				} else {
+					assert.NoError(t, err)
+				}
+			})
+		}
+	})
+
+	sources := map[string]image.Image{
+		"RGBA64":  image.NewRGBA64(image.Rect(0, 0, 128, 16)),
+		"NRGBA64": image.NewNRGBA64(image.Rect(0, 0, 128, 16)),
+		"Gray16":  image.NewGray16(image.Rect(0, 0, 128, 16)),
+	}
+
+	for name, src := range sources {
+		t.Run(fmt.Sprintf("%s at 10-bit", name), func(t *testing.T) {
+			dst := src.(draw.Image)
+			for y := 0; y < 16; y++ {
+				for x := 0; x < 128; x++ {
+					v := uint16(x * 512)
+					dst.Set(x, y, color.RGBA64{R: v, G: v, B: v, A: 0xffff})
+				}
+			}
+
+			decoded := encodeDecode(t, src, &avif.Options{Speed: 8, ColorQuality: 90, AlphaQuality: 90, Depth: 10})
+
+			for x := 0; x < 128; x += 16 {
+				r, _, _, _ := decoded.At(x, 8).RGBA()
+				assert.InDelta(t, x*512, int(r), 0x400, "pixel %d", x)
+			}
+		})
+	}
+}
+
+func TestEncode_Grayscale(t *testing.T) {
+	gray := image.NewGray(image.Rect(0, 0, 64, 32))
+	for y := 0; y < 32; y++ {
+		for x := 0; x < 64; x++ {
+			gray.SetGray(x, y, color.Gray{Y: uint8(x*4 + y)})
+		}
+	}
+
+	t.Run("gray round trips with neutral chroma", func(t *testing.T) {
+		decoded := encodeDecode(t, gray, &avif.Options{Speed: 8, ColorQuality: 90, AlphaQuality: 90})
+
+		for y := 0; y < 32; y += 5 {
+			for x := 0; x < 64; x += 7 {
+				r, g, b, _ := decoded.At(x, y).RGBA()
+				want := int(gray.GrayAt(x, y).Y) * 0x101
+				assert.InDelta(t, want, int(r), 8*0x101, "pixel %d,%d", x, y)
+				assert.InDelta(t, int(r), int(g), 2*0x101, "pixel %d,%d", x, y)
+				assert.InDelta(t, int(r), int(b), 2*0x101, "pixel %d,%d", x, y)
+			}
+		}
+	})
+
+	t.Run("gray16 at 10-bit", func(t *testing.T) {
+		gray16 := image.NewGray16(image.Rect(0, 0, 64, 32))
+		for y := 0; y < 32; y++ {
+			for x := 0; x < 64; x++ {
+				gray16.SetGray16(x, y, color.Gray16{Y: uint16(x * 1024)})
+			}
+		}
+
+		decoded := encodeDecode(t, gray16, &avif.Options{Speed: 8, ColorQuality: 90, AlphaQuality: 90, Depth: 10})
+
+		for x := 0; x < 64; x += 8 {
+			y, _, _, _ := decoded.At(x, 16).RGBA()
+			assert.InDelta(t, x*1024, int(y), 0x400, "pixel %d", x)
+		}
+	})
+
+	t.Run("colour image decodes to image.RGBA", func(t *testing.T) {
+		decoded := encodeDecode(t, toRGBA(gray), &avif.Options{Speed: 8, ColorQuality: 60, AlphaQuality: 60})
+
+		_, ok := decoded.(*image.RGBA)
+		assert.True(t, ok, "expected *image.RGBA, got %T", decoded)
+	})
+}
+
+func TestEncode_Tiling(t *testing.T) {
+	img := newBlockImage(256, 256)
+
+	t.Run("grid cells", func(t *testing.T) {
+		buf := &bytes.Buffer{}
+		require.NoError(t, avif.Encode(buf, img, &avif.Options{Speed: 8, ColorQuality: 90, AlphaQuality: 90,
+			GridCellWidth: 128, GridCellHeight: 128}))
+
+		info, err := avif.Inspect(bytes.NewReader(buf.Bytes()))
+		require.NoError(t, err)
+		assert.Equal(t, 2, info.GridColumns)
+		assert.Equal(t, 2, info.GridRows)
+		assert.Equal(t, 256, info.Width)
+		assert.Equal(t, 256, info.Height)
+		assert.Nil(t, info.Transform.Crop, "cells dividing the image exactly need no padding")
+
+		decoded, err := avif.Decode(buf)
+		require.NoError(t, err)
+		assert.Equal(t, img.Bounds(), decoded.Bounds())
+		assert.Less(t, meanDifference(img, toRGBA(decoded)), 8.0)
+	})
+
+	t.Run("image within one cell", func(t *testing.T) {
+		buf := &bytes.Buffer{}
+		require.NoError(t, avif.Encode(buf, img, &avif.Options{Speed: 8, GridCellWidth: 256, GridCellHeight: 256}))
+
+		info, err := avif.Inspect(buf)
+		require.NoError(t, err)
+		assert.Zero(t, info.GridColumns)
+		assert.Zero(t, info.GridRows)
+	})
+
+	t.Run("AV1 tiles", func(t *testing.T) {
+		for _, options := range []avif.Options{
+			{TileColsLog2: 1},
+			{TileRowsLog2: 1, TileColsLog2: 2},
+			{AutoTiling: true},
+		} {
+			options.Speed = 8
+			options.ColorQuality = 90
+			options.AlphaQuality = 90
+
+			// Tiles are internal to the AV1 payload, so the image decodes like any other
+			decoded := encodeDecode(t, img, &options)
+			assert.Less(t, meanDifference(img, toRGBA(decoded)), 8.0, "%+v", options)
+		}
+	})
+
+	t.Run("validation", func(t *testing.T) {
+		tests := []struct {
+			name    string
+			options avif.Options
+			err     string
+		}{
+			{"tile rows", avif.Options{TileRowsLog2: 7}, "tile rows and columns log2 must be between 0 and 6"},
+			{"tile columns", avif.Options{TileColsLog2: -1}, "tile rows and columns log2 must be between 0 and 6"},
+			{"cell width too small", avif.Options{GridCellWidth: 126}, "grid cell width must be an even number"},
+			{"cell width odd", avif.Options{GridCellWidth: 129}, "grid cell width must be an even number"},
+			{"cell width too large", avif.Options{GridCellWidth: 16386}, "grid cell width must be an even number"},
+			{"cell height too large", avif.Options{GridCellHeight: 8706}, "grid cell height must be an even number"},
+		}
+
+		for _, tt := range tests {
+			t.Run(tt.name, func(t *testing.T) {
+				err := avif.Encode(&bytes.Buffer{}, img, &tt.options)
+				assert.ErrorContains(t, err, tt.err)
+			})
+		}
+	})
+
+	t.Run("smaller edge cells", func(t *testing.T) {
+		// libavif accepts cells in the last column and row that are smaller than the others, so no padding is needed
+		for _, size := range []image.Point{{300, 200}, {290, 130}} {
+			img := newTestImage(size.X, size.Y)
+			buf := &bytes.Buffer{}
+			require.NoError(t, avif.Encode(buf, img, &avif.Options{Speed: 8, ColorQuality: 90, AlphaQuality: 90,
+				GridCellWidth: 128, GridCellHeight: 128}))
+
+			info, err := avif.Inspect(bytes.NewReader(buf.Bytes()))
+			require.NoError(t, err)
+			assert.Equal(t, 3, info.GridColumns, "columns of %v", size)
+			assert.Equal(t, 2, info.GridRows, "rows of %v", size)
+			assert.Nil(t, info.Transform.Crop, "crop of %v", size)
+
+			decoded, err := avif.Decode(buf)
+			require.NoError(t, err)
+			assert.Equal(t, img.Bounds(), decoded.Bounds())
+			assert.Less(t, meanDifference(img, toRGBA(decoded)), 8.0)
+		}
+	})
+
+	t.Run("odd size", func(t *testing.T) {
+		// 3x2 cells of at most 102x102 pixels, the last column and row padded to an even size
+		img := newTestImage(301, 201)
+		options := &avif.Options{Speed: 8, ColorQuality: 90, AlphaQuality: 90, GridCellWidth: 128,
+			GridCellHeight: 128}
+
+		buf := &bytes.Buffer{}
+		require.NoError(t, avif.Encode(buf, img, options))
+		data := buf.Bytes()
+
+		info, err := avif.Inspect(bytes.NewReader(data))
+		require.NoError(t, err)
+		assert.Equal(t, 3, info.GridColumns)
+		assert.Equal(t, 2, info.GridRows)
+		assert.Equal(t, 301, info.Width)
+		assert.Equal(t, 201, info.Height)
+
+		decoded, err := avif.Decode(bytes.NewReader(data))
+		require.NoError(t, err)
+		assert.Equal(t, img.Bounds(), decoded.Bounds())
+		assert.Less(t, meanDifference(img, toRGBA(decoded)), 8.0)
+
+		// The padding repeats the last column and row, and is cropped away by a clean aperture
+		stored, metadata, err := avif.DecodeWithOptions(bytes.NewReader(data), &avif.DecodeOptions{
+			IgnoreTransforms: true})
+		require.NoError(t, err)
+		assert.Equal(t, image.Rect(0, 0, 302, 202), stored.Bounds())
+		assert.Equal(t, &image.Rectangle{Max: image.Pt(301, 201)}, metadata.Transform.Crop)
+		for _, p := range []image.Point{{301, 100}, {150, 201}, {301, 201}} {
+			r1, g1, b1, _ := stored.At(min(p.X, 300), min(p.Y, 200)).RGBA()
+			r2, g2, b2, _ := stored.At(p.X, p.Y).RGBA()
+			for i, pair := range [][2]uint32{{r1, r2}, {g1, g2}, {b1, b2}} {
+				assert.InDelta(t, pair[0], pair[1], 0x1000, "channel %d of the padding at %v", i, p)
+			}
+		}
+
+		// A crop in the options replaces the one hiding the padding
+		options.Crop = &image.Rectangle{Min: image.Pt(10, 20), Max: image.Pt(210, 170)}
+		buf.Reset()
+		require.NoError(t, avif.Encode(buf, img, options))
+		decoded, err = avif.Decode(buf)
+		require.NoError(t, err)
+		assert.Equal(t, image.Rect(0, 0, 200, 150), decoded.Bounds())
+	})
+
+	t.Run("larger than SVT-AV1 supports", func(t *testing.T) {
+		if testing.Short() {
+			t.Skip("encoding a 180 megapixel image is slow, skipping in short mode")
+		}
+
+		// Split into 2x2 cells of 10000x4500 pixels, under SVT-AV1's limit of 16384x8704
+		img := newTestImage(20000, 9000)
+		buf := &bytes.Buffer{}
+		require.NoError(t, avif.Encode(buf, img, &avif.Options{Speed: 10, ColorQuality: 60, AlphaQuality: 60}))
+		data := buf.Bytes()
+
+		info, err := avif.Inspect(bytes.NewReader(data))
+		require.NoError(t, err)
+		assert.Equal(t, 2, info.GridColumns)
+		assert.Equal(t, 2, info.GridRows)
+		assert.Equal(t, 20000, info.Width)
+		assert.Equal(t, 9000, info.Height)
+
+		decoded, err := avif.Decode(bytes.NewReader(data))
+		require.NoError(t, err)
+		require.Equal(t, img.Bounds(), decoded.Bounds())
+
+		// Check the corners of the image and of every cell
+		for _, x := range []int{0, 9999, 10000, 19999} {
+			for _, y := range []int{0, 4499, 4500, 8999} {
+				r1, g1, b1, _ := img.At(x, y).RGBA()
+				r2, g2, b2, _ := decoded.At(x, y).RGBA()
+				for i, pair := range [][2]uint32{{r1, r2}, {g1, g2}, {b1, b2}} {
+					assert.InDelta(t, pair[0], pair[1], 0x1000, "channel %d at (%d,%d)", i, x, y)
+				}
+			}
+		}
+	})
+
+	t.Run("grid cells not supported for animations", func(t *testing.T) {
+		_, err := avif.NewAnimationEncoder(&bytes.Buffer{}, &avif.AnimationOptions{
+			Options: avif.Options{GridCellWidth: 128},
+		})
+		assert.ErrorContains(t, err, "grid cells are not supported for animations")
+	})
+}
+
+func TestEncodeContext(t *testing.T) {
+	img := newTestImage(64, 64)
+
+	t.Run("completes with a live context", func(t *testing.T) {
+		buf := &bytes.Buffer{}
+		require.NoError(t, avif.EncodeContext(context.Background(), buf, img, nil))
+
+		decoded, err := avif.Decode(buf)
+		require.NoError(t, err)
+		assert.Equal(t, img.Bounds(), decoded.Bounds())
+	})
+
+	t.Run("cancelled context", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+
+		buf := &bytes.Buffer{}
+		err := avif.EncodeContext(ctx, buf, img, nil)
+
+		assert.ErrorIs(t, err, context.Canceled)
+		assert.Empty(t, buf.Bytes())
+	})
+
+	t.Run("cancelled between steps", func(t *testing.T) {
+		// Cancelled after the first cell is converted, before the image is encoded
+		ctx := newCountdownContext(1)
+
+		buf := &bytes.Buffer{}
+		err := avif.EncodeContext(ctx, buf, img, nil)
+
+		assert.ErrorIs(t, err, context.Canceled)
+		assert.Empty(t, buf.Bytes())
+	})
+
+	t.Run("expired deadline", func(t *testing.T) {
+		ctx, cancel := context.WithTimeout(context.Background(), -time.Second)
+		defer cancel()
+
+		err := avif.EncodeContext(ctx, &bytes.Buffer{}, img, nil)
+		assert.ErrorIs(t, err, context.DeadlineExceeded)
+	})
+}
+
+// encodeDecode encodes img with the given options and decodes the result back.
+func encodeDecode(t *testing.T, img image.Image, options *avif.Options) image.Image {
+	t.Helper()
+
+	buf := &bytes.Buffer{}
+	require.NoError(t, avif.Encode(buf, img, options))
+
+	decoded, err := avif.Decode(buf)
+	require.NoError(t, err)
+	require.Equal(t, img.Bounds().Size(), decoded.Bounds().Size())
+
+	return decoded
+}
+
 // errorWriter is a helper type that always returns an error on Write
 type errorWriter struct{}
 
@@ -0,0 +1,102 @@
+package tests
+
+import (
+	"context"
+	"image"
+	"image/color"
+	"image/draw"
+	"math/rand/v2"
+)
+
+// newTestImage creates an opaque RGBA image with a colour gradient.
+func newTestImage(width, height int) *image.RGBA {
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			img.Set(x, y, color.RGBA{R: uint8(x * 255 / width), G: uint8(y * 255 / height), B: 128, A: 255})
+		}
+	}
+	return img
+}
+
+// newBlockImage creates an opaque RGBA image made of 8x8 blocks of deterministic random colours. The blocks survive
+// lossy encoding, while any misplaced block shows up as a large difference.
+func newBlockImage(width, height int) *image.RGBA {
+	rng := rand.New(rand.NewPCG(uint64(width), uint64(height)))
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	for by := 0; by < height; by += 8 {
+		for bx := 0; bx < width; bx += 8 {
+			c := color.RGBA{R: uint8(rng.IntN(256)), G: uint8(rng.IntN(256)), B: uint8(rng.IntN(256)), A: 255}
+			draw.Draw(img, image.Rect(bx, by, bx+8, by+8), image.NewUniform(c), image.Point{}, draw.Src)
+		}
+	}
+	return img
+}
+
+// newNoiseImage creates an opaque RGBA image filled with deterministic noise, so every pixel is distinguishable.
+func newNoiseImage(width, height int) *image.RGBA {
+	rng := rand.New(rand.NewPCG(uint64(width), uint64(height)))
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	for i := 0; i < len(img.Pix); i += 4 {
+		img.Pix[i] = uint8(rng.IntN(256))
+		img.Pix[i+1] = uint8(rng.IntN(256))
+		img.Pix[i+2] = uint8(rng.IntN(256))
+		img.Pix[i+3] = 255
+	}
+	return img
+}
+
+// newTestFrames creates frames of a moving square, each one visibly different from the others.
+func newTestFrames(count, width, height int) []image.Image {
+	frames := make([]image.Image, count)
+	for i := range frames {
+		img := image.NewRGBA(image.Rect(0, 0, width, height))
+		for y := 0; y < height; y++ {
+			for x := 0; x < width; x++ {
+				c := color.RGBA{R: 20, G: 40, B: 200, A: 255}
+				if x >= i*4 && x < i*4+8 && y < 8 {
+					c = color.RGBA{R: 250, G: 250, B: 20, A: 255}
+				}
+				img.SetRGBA(x, y, c)
+			}
+		}
+		frames[i] = img
+	}
+	return frames
+}
+
+// toRGBA copies img into a new *image.RGBA.
+func toRGBA(img image.Image) *image.RGBA {
+	rgba := image.NewRGBA(img.Bounds())
+	draw.Draw(rgba, rgba.Bounds(), img, img.Bounds().Min, draw.Src)
+	return rgba
+}
+
+// meanDifference returns the average absolute difference between the samples of two images of the same size.
+func meanDifference(a, b *image.RGBA) float64 {
+	sum := 0
+	for i := range a.Pix {
+		d := int(a.Pix[i]) - int(b.Pix[i])
+		sum += max(d, -d)
+	}
+	return float64(sum) / float64(len(a.Pix))
+}
+
+// countdownContext is a context that reports itself cancelled once Err has been called a given number of times, to
+// cancel an operation between two of its steps.
+type countdownContext struct {
+	context.Context
+	remaining int
+}
+
+func newCountdownContext(checks int) *countdownContext {
+	return &countdownContext{Context: context.Background(), remaining: checks}
+}
+
+func (c *countdownContext) Err() error {
+	if c.remaining <= 0 {
+		return context.Canceled
+	}
+	c.remaining--
+	return nil
+}
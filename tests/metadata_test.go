@@ -0,0 +1,294 @@
+package tests
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"testing"
+
+	"github.com/DND-IT/avif-go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMetadata_Color(t *testing.T) {
+	img := newTestImage(32, 32)
+
+	t.Run("default color info", func(t *testing.T) {
+		_, metadata := encodeDecodeMetadata(t, img, &avif.Options{Speed: 8, ColorQuality: 60, AlphaQuality: 60})
+
+		assert.Equal(t, avif.DefaultColorInfo(), metadata.Color)
+	})
+
+	t.Run("display p3", func(t *testing.T) {
+		colorInfo := avif.ColorInfo{
+			ColorPrimaries:          avif.ColorPrimariesDisplayP3,
+			TransferCharacteristics: avif.TransferCharacteristicsSRGB,
+			MatrixCoefficients:      avif.MatrixCoefficientsBT709,
+			FullRange:               true,
+		}
+
+		_, metadata := encodeDecodeMetadata(t, img, &avif.Options{Speed: 8, ColorQuality: 60, AlphaQuality: 60,
+			Color: &colorInfo})
+
+		assert.Equal(t, colorInfo, metadata.Color)
+	})
+
+	t.Run("bt2020 pq limited range", func(t *testing.T) {
+		colorInfo := avif.ColorInfo{
+			ColorPrimaries:          avif.ColorPrimariesBT2020,
+			TransferCharacteristics: avif.TransferCharacteristicsPQ,
+			MatrixCoefficients:      avif.MatrixCoefficientsBT2020NCL,
+			FullRange:               false,
+		}
+
+		_, metadata := encodeDecodeMetadata(t, img, &avif.Options{Speed: 8, ColorQuality: 60, AlphaQuality: 60,
+			Depth: 10, Color: &colorInfo})
+
+		assert.Equal(t, colorInfo, metadata.Color)
+	})
+}
+
+func TestMetadata_ICCProfile(t *testing.T) {
+	img := newTestImage(32, 32)
+
+	t.Run("no profile", func(t *testing.T) {
+		_, metadata := encodeDecodeMetadata(t, img, &avif.Options{Speed: 8, ColorQuality: 60, AlphaQuality: 60})
+
+		assert.Nil(t, metadata.ICCProfile)
+	})
+
+	t.Run("round trip", func(t *testing.T) {
+		// libavif stores the profile as an opaque blob, so any payload works for a round trip
+		profile := bytes.Repeat([]byte("fake icc profile "), 64)
+
+		_, metadata := encodeDecodeMetadata(t, img, &avif.Options{Speed: 8, ColorQuality: 60, AlphaQuality: 60,
+			ICCProfile: profile})
+
+		assert.Equal(t, profile, metadata.ICCProfile)
+	})
+
+	t.Run("grayscale round trip", func(t *testing.T) {
+		gray := image.NewGray(image.Rect(0, 0, 16, 16))
+		profile := []byte("fake gray icc profile")
+
+		_, metadata := encodeDecodeMetadata(t, gray, &avif.Options{Speed: 8, ColorQuality: 60, AlphaQuality: 60,
+			ICCProfile: profile})
+
+		assert.Equal(t, profile, metadata.ICCProfile)
+	})
+}
+
+func TestMetadata_EXIFAndXMP(t *testing.T) {
+	img := newTestImage(32, 32)
+
+	// A little-endian TIFF header followed by an empty IFD
+	exif := []byte{'I', 'I', 0x2a, 0x00, 0x08, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00}
+	xmp := []byte(`<x:xmpmeta xmlns:x="adobe:ns:meta/">` +
+		`<rdf:RDF xmlns:rdf="http://www.w3.org/1999/02/22-rdf-syntax-ns#">` +
+		`<rdf:Description xmlns:dc="http://purl.org/dc/elements/1.1/" dc:creator="Jane Doe"/></rdf:RDF></x:xmpmeta>`)
+
+	encode := func(t *testing.T) []byte {
+		buf := &bytes.Buffer{}
+		require.NoError(t, avif.Encode(buf, img, &avif.Options{Speed: 8, ColorQuality: 60, AlphaQuality: 60,
+			EXIF: exif, XMP: xmp}))
+		return buf.Bytes()
+	}
+
+	t.Run("no metadata", func(t *testing.T) {
+		_, metadata := encodeDecodeMetadata(t, img, &avif.Options{Speed: 8, ColorQuality: 60, AlphaQuality: 60})
+
+		assert.Nil(t, metadata.EXIF)
+		assert.Nil(t, metadata.XMP)
+	})
+
+	t.Run("round trip", func(t *testing.T) {
+		_, metadata, err := avif.DecodeWithMetadata(bytes.NewReader(encode(t)))
+		require.NoError(t, err)
+
+		assert.Equal(t, exif, metadata.EXIF)
+		assert.Equal(t, xmp, metadata.XMP)
+	})
+
+	t.Run("ignore exif", func(t *testing.T) {
+		decoded, metadata, err := avif.DecodeWithOptions(bytes.NewReader(encode(t)),
+			&avif.DecodeOptions{IgnoreEXIF: true})
+		require.NoError(t, err)
+
+		assert.NotNil(t, decoded)
+		assert.Nil(t, metadata.EXIF)
+		assert.Equal(t, xmp, metadata.XMP)
+	})
+
+	t.Run("ignore xmp", func(t *testing.T) {
+		decoded, metadata, err := avif.DecodeWithOptions(bytes.NewReader(encode(t)),
+			&avif.DecodeOptions{IgnoreXMP: true})
+		require.NoError(t, err)
+
+		assert.NotNil(t, decoded)
+		assert.Equal(t, exif, metadata.EXIF)
+		assert.Nil(t, metadata.XMP)
+	})
+
+	t.Run("nil options", func(t *testing.T) {
+		_, metadata, err := avif.DecodeWithOptions(bytes.NewReader(encode(t)), nil)
+		require.NoError(t, err)
+
+		assert.Equal(t, exif, metadata.EXIF)
+		assert.Equal(t, xmp, metadata.XMP)
+	})
+}
+
+func TestMetadata_Transform(t *testing.T) {
+	img := newBlockImage(40, 20)
+	options := avif.Options{Speed: 8, ColorQuality: 95, AlphaQuality: 95}
+
+	for orientation := 1; orientation <= 8; orientation++ {
+		t.Run(fmt.Sprintf("orientation %d", orientation), func(t *testing.T) {
+			opts := options
+			opts.Orientation = orientation
+
+			buf := &bytes.Buffer{}
+			require.NoError(t, avif.Encode(buf, img, &opts))
+			data := buf.Bytes()
+
+			decoded, err := avif.Decode(bytes.NewReader(data))
+			require.NoError(t, err)
+			assertSamePicture(t, orient(img, orientation), decoded)
+
+			config, err := avif.DecodeConfig(bytes.NewReader(data))
+			require.NoError(t, err)
+			assert.Equal(t, decoded.Bounds().Dx(), config.Width)
+			assert.Equal(t, decoded.Bounds().Dy(), config.Height)
+
+			raw, metadata, err := avif.DecodeWithOptions(bytes.NewReader(data),
+				&avif.DecodeOptions{IgnoreTransforms: true})
+			require.NoError(t, err)
+			assert.Equal(t, orientation, metadata.Transform.Orientation)
+			assert.Nil(t, metadata.Transform.Crop)
+			assertSamePicture(t, img, raw)
+		})
+	}
+
+	t.Run("crop", func(t *testing.T) {
+		crop := image.Rect(4, 2, 20, 12)
+		opts := options
+		opts.Crop = &crop
+
+		buf := &bytes.Buffer{}
+		require.NoError(t, avif.Encode(buf, img, &opts))
+		data := buf.Bytes()
+
+		decoded, metadata, err := avif.DecodeWithMetadata(bytes.NewReader(data))
+		require.NoError(t, err)
+		require.NotNil(t, metadata.Transform.Crop)
+		assert.Equal(t, crop, *metadata.Transform.Crop)
+		assertSamePicture(t, img.SubImage(crop), decoded)
+
+		config, err := avif.DecodeConfig(bytes.NewReader(data))
+		require.NoError(t, err)
+		assert.Equal(t, crop.Dx(), config.Width)
+		assert.Equal(t, crop.Dy(), config.Height)
+
+		raw, _, err := avif.DecodeWithOptions(bytes.NewReader(data), &avif.DecodeOptions{IgnoreTransforms: true})
+		require.NoError(t, err)
+		assert.Equal(t, img.Bounds().Size(), raw.Bounds().Size())
+	})
+
+	t.Run("crop and rotation", func(t *testing.T) {
+		crop := image.Rect(0, 0, 16, 10)
+		opts := options
+		opts.Crop = &crop
+		opts.Orientation = 8
+
+		buf := &bytes.Buffer{}
+		require.NoError(t, avif.Encode(buf, img, &opts))
+
+		decoded, err := avif.Decode(buf)
+		require.NoError(t, err)
+		assertSamePicture(t, orient(toRGBA(img.SubImage(crop)), 8), decoded)
+	})
+
+	t.Run("validation", func(t *testing.T) {
+		outside := image.Rect(30, 10, 50, 30)
+		empty := image.Rect(5, 5, 5, 5)
+		tests := []struct {
+			name    string
+			options avif.Options
+			wantErr string
+		}{
+			{"orientation -1", avif.Options{Orientation: -1}, "orientation must be between 1 and 8"},
+			{"orientation 9", avif.Options{Orientation: 9}, "orientation must be between 1 and 8"},
+			{"crop outside", avif.Options{Crop: &outside}, "crop rectangle"},
+			{"crop empty", avif.Options{Crop: &empty}, "crop rectangle"},
+		}
+
+		for _, tt := range tests {
+			t.Run(tt.name, func(t *testing.T) {
+				tt.options.Speed = 8
+				err := avif.Encode(&bytes.Buffer{}, img, &tt.options)
+
+				assert.Error(t, err)
+				assert.Contains(t, err.Error(), tt.wantErr)
+			})
+		}
+	})
+}
+
+// assertSamePicture checks that got shows the same picture as want, allowing for the error of lossy encoding.
+func assertSamePicture(t *testing.T, want, got image.Image) {
+	t.Helper()
+
+	require.Equal(t, want.Bounds().Size(), got.Bounds().Size())
+	assert.Less(t, meanDifference(toRGBA(want), toRGBA(got)), 6.0)
+}
+
+// orient returns how img is displayed with the given EXIF orientation.
+func orient(img *image.RGBA, orientation int) *image.RGBA {
+	w, h := img.Bounds().Dx(), img.Bounds().Dy()
+	if orientation >= 5 {
+		w, h = h, w
+	}
+
+	out := image.NewRGBA(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			// Find the stored pixel that is displayed at (x, y)
+			var sx, sy int
+			switch orientation {
+			case 2:
+				sx, sy = w-1-x, y
+			case 3:
+				sx, sy = w-1-x, h-1-y
+			case 4:
+				sx, sy = x, h-1-y
+			case 5:
+				sx, sy = y, x
+			case 6:
+				sx, sy = y, w-1-x
+			case 7:
+				sx, sy = h-1-y, w-1-x
+			case 8:
+				sx, sy = h-1-y, x
+			default:
+				sx, sy = x, y
+			}
+			out.Set(x, y, img.At(img.Bounds().Min.X+sx, img.Bounds().Min.Y+sy))
+		}
+	}
+	return out
+}
+
+// encodeDecodeMetadata encodes img with the given options and decodes the result back together with its metadata.
+func encodeDecodeMetadata(t *testing.T, img image.Image, options *avif.Options) (image.Image, *avif.Metadata) {
+	t.Helper()
+
+	buf := &bytes.Buffer{}
+	require.NoError(t, avif.Encode(buf, img, options))
+
+	decoded, metadata, err := avif.DecodeWithMetadata(buf)
+	require.NoError(t, err)
+	require.NotNil(t, metadata)
+
+	return decoded, metadata
+}
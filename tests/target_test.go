@@ -0,0 +1,165 @@
+package tests
+
+import (
+	"bytes"
+	"context"
+	"image"
+	"image/color"
+	"image/draw"
+	"math/rand/v2"
+	"testing"
+
+	"github.com/DND-IT/avif-go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEncodeToSize(t *testing.T) {
+	img := newBlockImage(128, 96)
+
+	encodedSize := func(t *testing.T, quality int) int {
+		buf := &bytes.Buffer{}
+		require.NoError(t, avif.Encode(buf, img, &avif.Options{Speed: 8, ColorQuality: quality, AlphaQuality: quality}))
+		return buf.Len()
+	}
+
+	t.Run("fits the target", func(t *testing.T) {
+		target := (encodedSize(t, 40) + encodedSize(t, 80)) / 2
+
+		buf := &bytes.Buffer{}
+		quality, err := avif.EncodeToSize(buf, img, target, &avif.Options{Speed: 8})
+		require.NoError(t, err)
+		assert.LessOrEqual(t, buf.Len(), target)
+		assert.GreaterOrEqual(t, quality, 40)
+		assert.Less(t, quality, 80)
+
+		// The reported quality is the one the output was encoded with
+		again := &bytes.Buffer{}
+		require.NoError(t, avif.Encode(again, img, &avif.Options{Speed: 8, ColorQuality: quality,
+			AlphaQuality: quality}))
+		assert.Equal(t, again.Bytes(), buf.Bytes())
+
+		decoded, err := avif.Decode(buf)
+		require.NoError(t, err)
+		assert.Equal(t, img.Bounds(), decoded.Bounds())
+	})
+
+	t.Run("generous target keeps full quality", func(t *testing.T) {
+		buf := &bytes.Buffer{}
+		quality, err := avif.EncodeToSize(buf, img, 1<<20, &avif.Options{Speed: 8})
+		require.NoError(t, err)
+		assert.Equal(t, 100, quality)
+	})
+
+	t.Run("unreachable target", func(t *testing.T) {
+		buf := &bytes.Buffer{}
+		_, err := avif.EncodeToSize(buf, img, 10, nil)
+		assert.ErrorIs(t, err, avif.ErrTargetUnreachable)
+		assert.Empty(t, buf.Bytes())
+	})
+
+	t.Run("invalid target", func(t *testing.T) {
+		_, err := avif.EncodeToSize(&bytes.Buffer{}, img, 0, nil)
+		assert.ErrorContains(t, err, "target size must be positive")
+	})
+
+	t.Run("invalid options", func(t *testing.T) {
+		_, err := avif.EncodeToSize(&bytes.Buffer{}, img, 1<<20, &avif.Options{Speed: 11})
+		assert.ErrorContains(t, err, "speed must be between 0 and 10")
+	})
+
+	t.Run("cancelled between attempts", func(t *testing.T) {
+		// The cell is converted and encoded once before the second attempt is cancelled
+		buf := &bytes.Buffer{}
+		_, err := avif.EncodeToSizeContext(newCountdownContext(2), buf, img, 1<<20, nil)
+		assert.ErrorIs(t, err, context.Canceled)
+		assert.Empty(t, buf.Bytes())
+	})
+
+	t.Run("write error", func(t *testing.T) {
+		_, err := avif.EncodeToSize(&errorWriter{}, img, 1<<20, nil)
+		assert.ErrorContains(t, err, "write error")
+	})
+}
+
+func TestEncodeToSSIM(t *testing.T) {
+	img := newBlockImage(128, 96)
+
+	t.Run("meets the score", func(t *testing.T) {
+		buf := &bytes.Buffer{}
+		quality, score, err := avif.EncodeToSSIM(buf, img, 0.9, &avif.Options{Speed: 8})
+		require.NoError(t, err)
+		assert.GreaterOrEqual(t, score, 0.9)
+		assert.LessOrEqual(t, score, 1.0)
+		assert.Less(t, quality, 100)
+
+		// The reported quality is the one the output was encoded with
+		again := &bytes.Buffer{}
+		require.NoError(t, avif.Encode(again, img, &avif.Options{Speed: 8, ColorQuality: quality,
+			AlphaQuality: quality}))
+		assert.Equal(t, again.Bytes(), buf.Bytes())
+
+		// A stricter score needs at least as much quality, and the output is closer to the source
+		strict := &bytes.Buffer{}
+		strictQuality, strictScore, err := avif.EncodeToSSIM(strict, img, 0.98, &avif.Options{Speed: 8})
+		require.NoError(t, err)
+		assert.GreaterOrEqual(t, strictScore, 0.98)
+		assert.GreaterOrEqual(t, strictQuality, quality)
+
+		lenient, err := avif.Decode(buf)
+		require.NoError(t, err)
+		closer, err := avif.Decode(strict)
+		require.NoError(t, err)
+		assert.LessOrEqual(t, meanDifference(img, toRGBA(closer)), meanDifference(img, toRGBA(lenient)))
+	})
+
+	t.Run("edges are measured", func(t *testing.T) {
+		// 8x8 windows 4 pixels apart stop 3 pixels short of the edges of an 11x11 image, so put all the detail there
+		edges := image.NewRGBA(image.Rect(0, 0, 11, 11))
+		rng := rand.New(rand.NewPCG(1, 2))
+		for y := 0; y < 11; y++ {
+			for x := 0; x < 11; x++ {
+				v := uint8(128)
+				if x >= 8 || y >= 8 {
+					v = uint8(rng.IntN(256))
+				}
+				edges.SetRGBA(x, y, color.RGBA{R: v, G: v, B: v, A: 255})
+			}
+		}
+
+		// Without the detail at the edges, the lowest quality meets the score
+		flat := image.NewRGBA(edges.Rect)
+		draw.Draw(flat, flat.Rect, image.NewUniform(color.RGBA{R: 128, G: 128, B: 128, A: 255}), image.Point{},
+			draw.Src)
+		flatQuality, _, err := avif.EncodeToSSIM(&bytes.Buffer{}, flat, 0.995, &avif.Options{Speed: 8})
+		require.NoError(t, err)
+
+		quality, score, err := avif.EncodeToSSIM(&bytes.Buffer{}, edges, 0.995, &avif.Options{Speed: 8})
+		require.NoError(t, err)
+		assert.GreaterOrEqual(t, score, 0.995)
+		assert.Greater(t, quality, flatQuality)
+	})
+
+	t.Run("unreachable score", func(t *testing.T) {
+		// 4:2:0 subsampling alone keeps the coloured blocks from being reproduced exactly
+		buf := &bytes.Buffer{}
+		_, _, err := avif.EncodeToSSIM(buf, img, 1, &avif.Options{Speed: 8})
+		assert.ErrorIs(t, err, avif.ErrTargetUnreachable)
+		assert.Empty(t, buf.Bytes())
+	})
+
+	t.Run("invalid score", func(t *testing.T) {
+		for _, score := range []float64{0, -0.5, 1.5} {
+			_, _, err := avif.EncodeToSSIM(&bytes.Buffer{}, img, score, nil)
+			assert.ErrorContains(t, err, "minimum score must be above 0 and up to 1")
+		}
+	})
+
+	t.Run("cancelled while searching", func(t *testing.T) {
+		// The cell is converted, then the first attempt is encoded before its decoding is cancelled
+		buf := &bytes.Buffer{}
+		_, _, err := avif.EncodeToSSIMContext(newCountdownContext(2), buf, img, 0.9, nil)
+		assert.ErrorIs(t, err, context.Canceled)
+		assert.Empty(t, buf.Bytes())
+	})
+}
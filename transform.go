@@ -0,0 +1,128 @@
+package avif
+
+import (
+	"image"
+)
+
+// Transform describes the orientation and clean aperture properties (irot, imir and clap) of an AVIF image.
+//   - Orientation: The EXIF orientation (1-8) equivalent to the irot and imir properties; 1 means the image is stored
+//     upright.
+//   - Crop: The clean aperture in stored pixel coordinates, or nil when the whole image is shown.
+type Transform struct {
+	Orientation int
+	Crop        *image.Rectangle
+}
+
+// orientations maps an EXIF orientation to the anti-clockwise irot angle (in 90 degree steps) and imir axis (-1 for no
+// mirroring) that reproduce it. As in MIAF, irot is applied before imir.
+var orientations = [9]struct{ angle, axis int }{
+	{0, -1}, // unused
+	{0, -1},
+	{0, 1},
+	{2, -1},
+	{0, 0},
+	{1, 0},
+	{3, -1},
+	{3, 0},
+	{1, -1},
+}
+
+// orientationFromIrotImir returns the EXIF orientation equivalent to an irot angle and imir axis (-1 for no mirroring).
+func orientationFromIrotImir(angle, axis int) int {
+	for orientation := 1; orientation < len(orientations); orientation++ {
+		if orientations[orientation].angle == angle && orientations[orientation].axis == axis {
+			return orientation
+		}
+	}
+
+	// Mirroring along one axis equals rotating by 180 degrees and mirroring along the other one
+	if axis >= 0 {
+		return orientationFromIrotImir((angle+2)%4, 1-axis)
+	}
+	return 1
+}
+
+// applyTransform crops img to the clean aperture and turns it upright according to the orientation. Images that need
+// no transformation are returned as is, and image.YCbCr images can only be cropped.
+func applyTransform(img image.Image, transform Transform) image.Image {
+	if transform.Orientation <= 1 && transform.Crop == nil {
+		return img
+	}
+
+	switch src := img.(type) {
+	case *image.RGBA:
+		pix, stride, rect := transformPixels(src.Pix, src.Stride, 4, src.Rect, transform)
+		return &image.RGBA{Pix: pix, Stride: stride, Rect: rect}
+	case *image.NRGBA:
+		pix, stride, rect := transformPixels(src.Pix, src.Stride, 4, src.Rect, transform)
+		return &image.NRGBA{Pix: pix, Stride: stride, Rect: rect}
+	case *image.Gray:
+		pix, stride, rect := transformPixels(src.Pix, src.Stride, 1, src.Rect, transform)
+		return &image.Gray{Pix: pix, Stride: stride, Rect: rect}
+	case *image.RGBA64:
+		pix, stride, rect := transformPixels(src.Pix, src.Stride, 8, src.Rect, transform)
+		return &image.RGBA64{Pix: pix, Stride: stride, Rect: rect}
+	case *image.NRGBA64:
+		pix, stride, rect := transformPixels(src.Pix, src.Stride, 8, src.Rect, transform)
+		return &image.NRGBA64{Pix: pix, Stride: stride, Rect: rect}
+	case *image.Gray16:
+		pix, stride, rect := transformPixels(src.Pix, src.Stride, 2, src.Rect, transform)
+		return &image.Gray16{Pix: pix, Stride: stride, Rect: rect}
+	case *image.YCbCr:
+		if transform.Crop != nil && transform.Orientation <= 1 {
+			return src.SubImage(transform.Crop.Add(src.Rect.Min))
+		}
+	}
+
+	return img
+}
+
+// transformPixels copies the cropped region of a packed pixel buffer into a new buffer, moving every pixel to its
+// upright position. bpp is the number of bytes per pixel.
+func transformPixels(pix []byte, stride, bpp int, rect image.Rectangle, transform Transform) ([]byte, int,
+	image.Rectangle) {
+	crop := rect
+	if transform.Crop != nil {
+		crop = transform.Crop.Add(rect.Min).Intersect(rect)
+	}
+
+	width := crop.Dx()
+	height := crop.Dy()
+	outW, outH := width, height
+	if transform.Orientation >= 5 {
+		outW, outH = height, width
+	}
+
+	outStride := outW * bpp
+	out := make([]byte, outStride*outH)
+
+	for y := 0; y < height; y++ {
+		srcRow := (crop.Min.Y-rect.Min.Y+y)*stride + (crop.Min.X-rect.Min.X)*bpp
+		for x := 0; x < width; x++ {
+			var dx, dy int
+			switch transform.Orientation {
+			case 2:
+				dx, dy = width-1-x, y
+			case 3:
+				dx, dy = width-1-x, height-1-y
+			case 4:
+				dx, dy = x, height-1-y
+			case 5:
+				dx, dy = y, x
+			case 6:
+				dx, dy = height-1-y, x
+			case 7:
+				dx, dy = height-1-y, width-1-x
+			case 8:
+				dx, dy = y, width-1-x
+			default:
+				dx, dy = x, y
+			}
+
+			dst := dy*outStride + dx*bpp
+			copy(out[dst:dst+bpp], pix[srcRow+x*bpp:srcRow+(x+1)*bpp])
+		}
+	}
+
+	return out, outStride, image.Rect(0, 0, outW, outH)
+}